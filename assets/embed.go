@@ -2,6 +2,7 @@ package assets
 
 import (
 	_ "embed"
+	"os"
 	"text/template"
 )
 
@@ -12,3 +13,17 @@ var CloudInitTemplate *template.Template
 func init() {
 	CloudInitTemplate = template.Must(template.New("cloudinit").Parse(string(cloudInitTemplateRaw)))
 }
+
+// LoadCloudInitTemplate parses a user supplied cloud-init template; an empty path returns the embedded default template
+func LoadCloudInitTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return CloudInitTemplate, nil
+	}
+
+	raw, readError := os.ReadFile(path)
+	if readError != nil {
+		return nil, readError
+	}
+
+	return template.New("cloudinit").Parse(string(raw))
+}
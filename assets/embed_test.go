@@ -2,6 +2,8 @@ package assets_test
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -39,6 +41,74 @@ func TestCloudInitTemplate(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "mount cache volume",
+			input: map[string]any{
+				"cache_volume_device": "/dev/disk/by-id/scsi-0HC_Volume_123",
+				"cache_path":          "/cache",
+			},
+			checkFunc: func(t *testing.T, output *bytes.Buffer) {
+				if !strings.Contains(output.String(), "- [ /dev/disk/by-id/scsi-0HC_Volume_123, /cache, ext4,") {
+					t.Fatalf("template output does not contain cache volume mount")
+				}
+			},
+		},
+		{
+			name: "configure build cache",
+			input: map[string]any{
+				"build_cache": map[string]any{
+					"apt_proxy":   "http://apt-cache:3142",
+					"environment": []string{`GOPROXY="https://goproxy.example.com"`},
+					"sccache": map[string]any{
+						"url":         "https://example.com/sccache.tar.gz",
+						"sha256":      "0123456789abcdef",
+						"config":      []string{"[cache.s3]", `bucket = "cache"`},
+						"credentials": []string{"[default]", "aws_secret_access_key = secret"},
+					},
+				},
+			},
+			checkFunc: func(t *testing.T, output *bytes.Buffer) {
+				for _, expected := range []string{
+					"  http_proxy: http://apt-cache:3142",
+					"      GOPROXY=\"https://goproxy.example.com\"",
+					"curl -fsSL --retry 3 --output \"$tmp/sccache.tar.gz\" \"https://example.com/sccache.tar.gz\"",
+					"echo \"0123456789abcdef  $tmp/sccache.tar.gz\" | sha256sum -c -",
+					"  - path: /etc/sccache/config\n    permissions: \"0600\"\n    content: |\n      [cache.s3]\n      bucket = \"cache\"\n",
+					"  - path: /etc/sccache/credentials\n    permissions: \"0600\"\n    content: |\n      [default]\n      aws_secret_access_key = secret\n",
+					"- /usr/local/sbin/hmp-install-sccache || exit 1",
+				} {
+					if !strings.Contains(output.String(), expected) {
+						t.Fatalf("template output does not contain %q", expected)
+					}
+				}
+				environment := output.String()[strings.Index(output.String(), "path: /etc/environment"):]
+				if strings.Contains(environment[:strings.Index(environment, "runcmd:")], "secret") {
+					t.Fatalf("template output exposes the credentials in /etc/environment")
+				}
+			},
+		},
+		{
+			name: "verify gitlab-runner download",
+			input: map[string]any{
+				"gitlab_runner": map[string]any{
+					"binary_url":     "https://mirror.example.com/v17.5.0/binaries/gitlab-runner-linux-amd64",
+					"checksum_url":   "https://mirror.example.com/v17.5.0/release.sha256",
+					"checksum_entry": "binaries/gitlab-runner-linux-amd64",
+				},
+			},
+			checkFunc: func(t *testing.T, output *bytes.Buffer) {
+				for _, expected := range []string{
+					"\"https://mirror.example.com/v17.5.0/binaries/gitlab-runner-linux-amd64\"",
+					"\"https://mirror.example.com/v17.5.0/release.sha256\"",
+					"sha256sum -c -",
+					"- /usr/local/sbin/hmp-install-gitlab-runner || exit 1",
+				} {
+					if !strings.Contains(output.String(), expected) {
+						t.Fatalf("template output does not contain %q", expected)
+					}
+				}
+			},
+		},
 	} {
 		t.Run(testCase.name, func(t *testing.T) {
 			buf := &bytes.Buffer{}
@@ -52,3 +122,32 @@ func TestCloudInitTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadCloudInitTemplate(t *testing.T) {
+	defaultTemplate, err := assets.LoadCloudInitTemplate("")
+	if err != nil || defaultTemplate != assets.CloudInitTemplate {
+		t.Fatalf("expected embedded template, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cloud-init.tmpl")
+	if err := os.WriteFile(path, []byte("#cloud-config\nruncmd: [echo {{ .architecture }}]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	overrideTemplate, err := assets.LoadCloudInitTemplate(path)
+	if err != nil {
+		t.Fatalf("failed to load template: %s", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := overrideTemplate.Execute(buf, map[string]any{"architecture": "arm64"}); err != nil {
+		t.Fatalf("failed to execute template: %s", err)
+	}
+	if !strings.Contains(buf.String(), "echo arm64") {
+		t.Fatalf("template output does not contain rendered override")
+	}
+
+	if _, err := assets.LoadCloudInitTemplate(filepath.Join(t.TempDir(), "missing.tmpl")); err == nil {
+		t.Fatalf("expected error for missing template")
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/fatih/color"
@@ -11,38 +13,125 @@ import (
 
 	"github.com/bonsai-oss/hetzner-machine-provider/internal/actions"
 	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider/hetzner"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider/static"
 )
 
 var version = "dev"
 
+const (
+	stateBackendLocal = "local"
+	stateBackendS3    = "s3"
+)
+
 type application struct {
-	hcloudToken string
-	jobID       string
+	hcloudToken    string
+	jobID          string
+	stateSecret    string
+	stateInsecure  bool
+	stateDir       string
+	stateBackend   string
+	stateS3Options helper.S3Client
+	stateS3Prefix  string
+
+	showSecrets bool
+
+	metadataCache helper.FileCache
 
 	execScriptPath string
 	execStageName  string
 
-	hcloudClient *hcloud.Client
+	providerName     string
+	staticHosts      string
+	staticHostsFile  string
+	imageAliasesFile string
+	staticOptions    static.Options
+	hcloudClient     *hetzner.Client
+	machineProvider  provider.Provider
+	stateStore       *helper.StateStore
 
 	vmParams actions.VMParams
 
 	resourceNamePrefix string
 	prepareOptions     actions.PrepareOptions
+	hetznerOptions     hetzner.Options
+	imageBuildOptions  hetzner.ImageBuildOptions
+	imagePruneOptions  hetzner.ImagePruneOptions
+	imagePruneMaxAge   string
 }
 
 func (a *application) prepare(_ *kingpin.ParseContext) error {
 	color.Green("🚀 Preparing environment")
 	a.prepareOptions.JobID = a.jobID
-	return actions.Prepare(a.hcloudClient, a.prepareOptions, a.vmParams)
+	// static hosts cannot be provisioned with a generated key
+	if a.providerName == static.Name && a.prepareOptions.SSHKeySource != actions.SSHKeySourceAgent {
+		return fmt.Errorf("provider %+q requires ssh key source %+q", static.Name, actions.SSHKeySourceAgent)
+	}
+	return actions.Prepare(a.machineProvider, a.stateStore, a.prepareOptions, a.vmParams)
 }
 
 func (a *application) cleanup(_ *kingpin.ParseContext) error {
 	color.Green("🧼 Cleaning up resources")
-	return actions.Cleanup(a.hcloudClient, a.jobID)
+	return actions.Cleanup(a.machineProvider, a.stateStore, a.jobID)
 }
 
 func (a *application) exec(_ *kingpin.ParseContext) error {
-	return actions.Exec(a.execScriptPath, a.execStageName)
+	return actions.Exec(a.stateStore, a.jobID, a.execScriptPath, a.execStageName)
+}
+
+func (a *application) stateList(_ *kingpin.ParseContext) error {
+	return actions.StateList(a.stateStore)
+}
+
+func (a *application) stateShow(_ *kingpin.ParseContext) error {
+	return actions.StateShow(a.stateStore, a.jobID, a.showSecrets)
+}
+
+func (a *application) stateRemove(_ *kingpin.ParseContext) error {
+	return actions.StateRemove(a.stateStore, a.jobID)
+}
+
+func (a *application) cacheRefresh(_ *kingpin.ParseContext) error {
+	return hetzner.CacheRefresh(a.hcloudClient, &a.metadataCache)
+}
+
+func (a *application) gc(_ *kingpin.ParseContext) error {
+	return hetzner.GC(a.hcloudClient)
+}
+
+func (a *application) imageBuild(_ *kingpin.ParseContext) error {
+	color.Green("📀 Building image")
+	return hetzner.BuildImage(hetzner.New(a.hcloudClient, hetzner.Options{MetadataCache: &a.metadataCache}), a.imageBuildOptions)
+}
+
+func (a *application) imagePrune(_ *kingpin.ParseContext) error {
+	maxAge, parseError := helper.ParseDuration(a.imagePruneMaxAge)
+	if parseError != nil {
+		return parseError
+	}
+	a.imagePruneOptions.MaxAge = maxAge
+	return hetzner.PruneImages(a.hcloudClient, a.imagePruneOptions)
+}
+
+func (a *application) cacheClear(_ *kingpin.ParseContext) error {
+	return hetzner.CacheClear(&a.metadataCache)
+}
+
+func (a *application) prepareStateStore(_ *kingpin.ParseContext) error {
+	a.stateStore = &helper.StateStore{Secret: a.stateSecret, InsecurePlaintext: a.stateInsecure}
+
+	switch a.stateBackend {
+	case stateBackendLocal:
+		a.stateStore.Backend = &helper.LocalStateBackend{BaseDir: a.stateDir}
+	case stateBackendS3:
+		if a.stateSecret == "" {
+			return fmt.Errorf("state backend %+q requires a state secret", a.stateBackend)
+		}
+		a.stateStore.Backend = &helper.S3StateBackend{Client: &a.stateS3Options, Prefix: a.stateS3Prefix}
+	}
+
+	return nil
 }
 
 func (a *application) configure(_ *kingpin.ParseContext) error {
@@ -58,8 +147,64 @@ func (a *application) configure(_ *kingpin.ParseContext) error {
 	return json.NewEncoder(os.Stdout).Encode(data)
 }
 
+// checkStateSecret fails if generated private keys could not be stored; it runs on configure, so a runner missing the
+// state secret fails every job at startup, before a server is created
+func (a *application) checkStateSecret(_ *kingpin.ParseContext) error {
+	if a.prepareOptions.SSHKeySource == actions.SSHKeySourceGenerate && a.stateSecret == "" && !a.stateInsecure {
+		return fmt.Errorf("ssh key source %+q requires a state secret: %w", actions.SSHKeySourceGenerate, helper.ErrPlaintextPrivateKey)
+	}
+	return nil
+}
+
 func (a *application) prepareClient(_ *kingpin.ParseContext) error {
-	a.hcloudClient = hcloud.NewClient(hcloud.WithToken(a.hcloudToken), hcloud.WithApplication("hmp", version))
+	if a.hcloudToken == "" {
+		return fmt.Errorf("required flag --hcloud-token not provided")
+	}
+	a.hcloudClient = hetzner.NewClient(hcloud.NewClient(
+		hcloud.WithToken(a.hcloudToken),
+		hcloud.WithApplication("hmp", version),
+		hcloud.WithHTTPClient(&http.Client{Transport: helper.NewRateLimitTransport(http.DefaultTransport)}),
+		// retries are left to the rate limit transport, which knows the remaining budget; retrying in both places
+		// would multiply the attempts per request
+		hcloud.WithRetryOpts(hcloud.RetryOpts{MaxRetries: 0}),
+	))
+	return nil
+}
+
+func (a *application) prepareProvider(ctx *kingpin.ParseContext) error {
+	switch a.providerName {
+	case hetzner.Name:
+		if clientError := a.prepareClient(ctx); clientError != nil {
+			return clientError
+		}
+		a.hetznerOptions.MetadataCache = &a.metadataCache
+		a.hetznerOptions.CacheVolumeLockGracePeriod = hetzner.CacheVolumeLockGracePeriod(a.prepareOptions.WaitDeadline)
+		if a.imageAliasesFile != "" {
+			var readError error
+			if a.hetznerOptions.ImageAliases, readError = hetzner.ReadImageAliases(a.imageAliasesFile); readError != nil {
+				return readError
+			}
+		}
+		a.machineProvider = hetzner.New(a.hcloudClient, a.hetznerOptions)
+	case static.Name:
+		hosts, parseError := static.ParseHosts(a.staticHosts)
+		if parseError != nil {
+			return parseError
+		}
+		if a.staticHostsFile != "" {
+			fileHosts, readError := static.ReadHostsFile(a.staticHostsFile)
+			if readError != nil {
+				return readError
+			}
+			hosts = append(hosts, fileHosts...)
+		}
+		a.staticOptions.Hosts = hosts
+		a.staticOptions.Store = a.stateStore
+		var providerError error
+		a.machineProvider, providerError = static.New(a.staticOptions)
+		return providerError
+	}
+
 	return nil
 }
 
@@ -70,6 +215,19 @@ func main() {
 	kingpinApp.HelpFlag.Short('h')
 	kingpinApp.Version(version)
 	kingpinApp.Flag("resource-name-prefix", "cloud resource name prefix").Envar("CUSTOM_ENV_HMP_RESOURCE_NAME_PREFIX").Default("hmp-job-").StringVar(&app.resourceNamePrefix)
+	kingpinApp.Flag("state-secret", "runner-level secret used to encrypt the job state at rest").Envar("HMP_STATE_SECRET").StringVar(&app.stateSecret)
+	kingpinApp.Flag("state-insecure-plaintext", "store generated ssh private keys unencrypted if no state secret is configured").Envar("HMP_STATE_INSECURE_PLAINTEXT").BoolVar(&app.stateInsecure)
+	kingpinApp.Flag("state-backend", "backend used to persist job states").Envar("HMP_STATE_BACKEND").Default(stateBackendLocal).EnumVar(&app.stateBackend, stateBackendLocal, stateBackendS3)
+	kingpinApp.Flag("state-dir", "base directory for job states (local backend)").Envar("HMP_STATE_DIR").Default(helper.DefaultStateDir()).StringVar(&app.stateDir)
+	kingpinApp.Flag("state-s3-endpoint", "s3 endpoint, e.g. https://fsn1.your-objectstorage.com (s3 backend)").Envar("HMP_STATE_S3_ENDPOINT").StringVar(&app.stateS3Options.Endpoint)
+	kingpinApp.Flag("state-s3-region", "s3 region (s3 backend)").Envar("HMP_STATE_S3_REGION").Default("us-east-1").StringVar(&app.stateS3Options.Region)
+	kingpinApp.Flag("state-s3-bucket", "s3 bucket (s3 backend)").Envar("HMP_STATE_S3_BUCKET").StringVar(&app.stateS3Options.Bucket)
+	kingpinApp.Flag("state-s3-prefix", "object key prefix for job states (s3 backend)").Envar("HMP_STATE_S3_PREFIX").Default("hmp/").StringVar(&app.stateS3Prefix)
+	kingpinApp.Flag("state-s3-access-key", "s3 access key (s3 backend)").Envar("HMP_STATE_S3_ACCESS_KEY").StringVar(&app.stateS3Options.AccessKey)
+	kingpinApp.Flag("state-s3-secret-key", "s3 secret key (s3 backend)").Envar("HMP_STATE_S3_SECRET_KEY").StringVar(&app.stateS3Options.SecretKey)
+	kingpinApp.Flag("state-s3-virtual-hosted-style", "address the bucket as subdomain of the endpoint instead of using path-style requests (s3 backend)").Envar("HMP_STATE_S3_VIRTUAL_HOSTED_STYLE").BoolVar(&app.stateS3Options.VirtualHostedStyle)
+	kingpinApp.Flag("metadata-cache-dir", "directory to cache server types, datacenters and system images in").Envar("HMP_METADATA_CACHE_DIR").Default(helper.DefaultCacheDir()).StringVar(&app.metadataCache.Dir)
+	kingpinApp.Flag("metadata-cache-ttl", "time to live of cached server types and system images; datacenters are cached for 5m at most; 0 disables the cache").Envar("HMP_METADATA_CACHE_TTL").Default("24h").DurationVar(&app.metadataCache.TTL)
 
 	// set resource name prefix before any command is executed
 	kingpinApp.PreAction(func(_ *kingpin.ParseContext) error {
@@ -80,25 +238,103 @@ func main() {
 		return validationError
 	})
 
-	prepareCmd := kingpinApp.Command("prepare", "prepare the environment").PreAction(app.prepareClient).Action(app.prepare)
-	prepareCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").Required().StringVar(&app.hcloudToken)
+	prepareCmd := kingpinApp.Command("prepare", "prepare the environment").PreAction(app.checkStateSecret).PreAction(app.prepareStateStore).PreAction(app.prepareProvider).Action(app.prepare)
+	prepareCmd.Flag("provider", "provider of the job machines").Envar("HMP_PROVIDER").Default(hetzner.Name).EnumVar(&app.providerName, hetzner.Name, static.Name)
+	prepareCmd.Flag("static-hosts", "hosts used by the static provider as host[:port], separated by ','").Envar("HMP_STATIC_HOSTS").StringVar(&app.staticHosts)
+	prepareCmd.Flag("static-hosts-file", "file listing hosts used by the static provider, one per line").Envar("HMP_STATIC_HOSTS_FILE").StringVar(&app.staticHostsFile)
+	prepareCmd.Flag("static-lease-dir", "directory of the static host leases").Envar("HMP_STATIC_LEASE_DIR").Default(filepath.Join(helper.DefaultStateDir(), "leases")).StringVar(&app.staticOptions.LeaseDir)
+	prepareCmd.Flag("static-lease-timeout", "time to wait for a free static host").Envar("HMP_STATIC_LEASE_TIMEOUT").Default("10m").DurationVar(&app.staticOptions.LeaseTimeout)
+	prepareCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").StringVar(&app.hcloudToken)
 	prepareCmd.Flag("job-id", "job id").Envar("CI_JOB_ID").Envar("CUSTOM_ENV_CI_JOB_ID").Required().StringVar(&app.jobID)
 	prepareCmd.Flag("prepare.server-wait-deadline", "deadline for server to become reachable").Envar("CUSTOM_ENV_HMP_SERVER_WAIT_DEADLINE").Default("5m").DurationVar(&app.prepareOptions.WaitDeadline)
-	prepareCmd.Flag("prepare.additional-authorized-keys", "specify additional authorized keys separated by '\\n'").Envar("CUSTOM_ENV_HMP_ADDITIONAL_AUTHORIZED_KEYS").StringVar(&app.prepareOptions.AdditionalAuthorizedKeys)
+	prepareCmd.Flag("prepare.additional-authorized-keys", "specify additional authorized keys separated by '\\n'").Envar("CUSTOM_ENV_HMP_ADDITIONAL_AUTHORIZED_KEYS").StringVar(&app.hetznerOptions.AdditionalAuthorizedKeys)
+	prepareCmd.Flag("prepare.ssh-key-type", "type of the generated ssh key").Envar("CUSTOM_ENV_HMP_SSH_KEY_TYPE").Default(helper.SSHKeyTypes[0]).EnumVar((*string)(&app.prepareOptions.SSHKeyType), helper.SSHKeyTypes...)
+	prepareCmd.Flag("prepare.ssh-key-source", "source of the ssh key; 'generate' creates a new key pair per job, 'agent' uses a key held by an ssh-agent").Envar("HMP_SSH_KEY_SOURCE").Default(actions.SSHKeySourceGenerate).EnumVar(&app.prepareOptions.SSHKeySource, actions.SSHKeySourceGenerate, actions.SSHKeySourceAgent)
+	prepareCmd.Flag("prepare.ssh-agent-socket", "ssh-agent socket used with ssh key source 'agent'").Envar("SSH_AUTH_SOCK").StringVar(&app.prepareOptions.SSHAgentSocket)
+	prepareCmd.Flag("prepare.ssh-agent-key", "SHA256 fingerprint or comment of the ssh-agent key to use; defaults to the first key").Envar("HMP_SSH_AGENT_KEY").StringVar(&app.prepareOptions.SSHAgentKey)
+	prepareCmd.Flag("prepare.cache-volumes", "attach a persistent cache volume per project").Envar("HMP_CACHE_VOLUMES").BoolVar(&app.hetznerOptions.CacheVolumes)
+	prepareCmd.Flag("prepare.cache-volume", "cache volume key; defaults to project id and location").Envar("CUSTOM_ENV_HMP_CACHE_VOLUME").StringVar(&app.hetznerOptions.CacheVolumeKey)
+	prepareCmd.Flag("prepare.cache-volume-size", "size of newly created cache volumes in GB").Envar("CUSTOM_ENV_HMP_CACHE_VOLUME_SIZE").Default("10").IntVar(&app.hetznerOptions.CacheVolumeSize)
+	prepareCmd.Flag("prepare.cache-path", "mount path of the cache volume").Envar("CUSTOM_ENV_HMP_CACHE_PATH").Default("/cache").StringVar(&app.hetznerOptions.CachePath)
+	prepareCmd.Flag("prepare.cache-s3-endpoint", "s3 endpoint of the build cache").Envar("HMP_CACHE_S3_ENDPOINT").StringVar(&app.hetznerOptions.BuildCache.S3Endpoint)
+	prepareCmd.Flag("prepare.cache-s3-bucket", "s3 bucket of the build cache").Envar("HMP_CACHE_S3_BUCKET").StringVar(&app.hetznerOptions.BuildCache.S3Bucket)
+	prepareCmd.Flag("prepare.cache-s3-region", "s3 region of the build cache").Envar("HMP_CACHE_S3_REGION").Default("us-east-1").StringVar(&app.hetznerOptions.BuildCache.S3Region)
+	prepareCmd.Flag("prepare.cache-s3-prefix", "object key prefix within the build cache bucket; each project's cache is nested below it").Envar("HMP_CACHE_S3_PREFIX").StringVar(&app.hetznerOptions.BuildCache.S3Prefix)
+	prepareCmd.Flag("prepare.cache-s3-access-key", "s3 access key of the build cache").Envar("HMP_CACHE_S3_ACCESS_KEY").StringVar(&app.hetznerOptions.BuildCache.S3AccessKey)
+	prepareCmd.Flag("prepare.cache-s3-secret-key", "s3 secret key of the build cache").Envar("HMP_CACHE_S3_SECRET_KEY").StringVar(&app.hetznerOptions.BuildCache.S3SecretKey)
+	prepareCmd.Flag("prepare.cache-sccache", "install sccache backed by the s3 build cache").Envar("CUSTOM_ENV_HMP_CACHE_SCCACHE").BoolVar(&app.hetznerOptions.BuildCache.Sccache)
+	prepareCmd.Flag("prepare.cache-sccache-version", "sccache version to install").Envar("HMP_CACHE_SCCACHE_VERSION").Default("0.8.2").StringVar(&app.hetznerOptions.BuildCache.SccacheVersion)
+	prepareCmd.Flag("prepare.cache-sccache-sha256", "sha256 checksums of the sccache release archives by architecture, e.g. amd64=<sha256>,arm64=<sha256>").Envar("HMP_CACHE_SCCACHE_SHA256").StringVar(&app.hetznerOptions.BuildCache.SccacheChecksums)
+	prepareCmd.Flag("prepare.cache-goproxy", "go module proxy url").Envar("CUSTOM_ENV_HMP_CACHE_GOPROXY").StringVar(&app.hetznerOptions.BuildCache.GoProxy)
+	prepareCmd.Flag("prepare.cache-apt-proxy", "apt proxy url, e.g. an apt-cacher-ng instance").Envar("CUSTOM_ENV_HMP_CACHE_APT_PROXY").StringVar(&app.hetznerOptions.BuildCache.AptProxy)
+	prepareCmd.Flag("image-aliases-file", "file mapping container style job images like node:20 to image selectors, one '<alias> <image selector>' pair per line").Envar("HMP_IMAGE_ALIASES_FILE").StringVar(&app.imageAliasesFile)
+	prepareCmd.Flag("reject-deprecated", "fail jobs selecting a deprecated server type or image instead of printing a warning").Envar("HMP_REJECT_DEPRECATED").BoolVar(&app.hetznerOptions.RejectDeprecated)
+	prepareCmd.Flag("cloud-init-template", "cloud-init template file overriding the embedded template").Envar("HMP_CLOUD_INIT_TEMPLATE").StringVar(&app.hetznerOptions.CloudInitTemplate)
+	prepareCmd.Flag("prepare.cloud-init-extra", "extra cloud-init packages, runcmd and write_files merged into the rendered template").Envar("CUSTOM_ENV_HMP_CLOUD_INIT_EXTRA").StringVar(&app.hetznerOptions.CloudInitExtra)
+	prepareCmd.Flag("prepare.runner-version", "gitlab-runner version installed on the server; defaults to the version of the invoking runner").Envar("HMP_RUNNER_VERSION").StringVar(&app.hetznerOptions.GitlabRunner.Version)
+	prepareCmd.Flag("prepare.runner-download-url", "base url of the gitlab-runner downloads, e.g. an internal mirror").Envar("HMP_RUNNER_DOWNLOAD_URL").Default(hetzner.DefaultGitlabRunnerDownloadURL).StringVar(&app.hetznerOptions.GitlabRunner.DownloadURL)
+	prepareCmd.Flag("prepare.readiness-commands", "commands which have to succeed on the server before it is considered ready, separated by '\\n'").Envar("CUSTOM_ENV_HMP_READINESS_COMMANDS").StringVar(&app.hetznerOptions.ReadinessCommands)
+	prepareCmd.Flag("prepare.placement-groups", "spread the servers of a pipeline across physical hosts using placement groups").Envar("CUSTOM_ENV_HMP_PLACEMENT_GROUPS").BoolVar(&app.hetznerOptions.PlacementGroups)
+	prepareCmd.Flag("prepare.diagnostics-dir", "directory to save diagnostics of servers which did not become ready to").Envar("HMP_DIAGNOSTICS_DIR").Default(filepath.Join(os.TempDir(), "hmp-diagnostics")).StringVar(&app.prepareOptions.DiagnosticsDir)
+	prepareCmd.Flag("keep-on-failure", "keep created resources for debugging if prepare fails").Envar("HMP_KEEP_ON_FAILURE").BoolVar(&app.prepareOptions.KeepOnFailure)
+	prepareCmd.Flag("prepare.attempts", "number of servers created at most if they do not become ready; the wait deadline is split between the attempts").Envar("CUSTOM_ENV_HMP_PREPARE_ATTEMPTS").Default("1").IntVar(&app.prepareOptions.Attempts)
 	prepareCmd.Flag("vm.image", "vm image").Envar("CUSTOM_ENV_CI_JOB_IMAGE").Default("ubuntu-22.04").StringVar(&app.vmParams.Image)
 	prepareCmd.Flag("vm.type", "vm type").Envar("CUSTOM_ENV_HCLOUD_SERVER_TYPE").Default("auto").StringVar(&app.vmParams.Type)
 	prepareCmd.Flag("vm.architecture", "vm architecture (only beeing used on vm.type 'auto'").Default("amd64").Envar("CUSTOM_ENV_HCLOUD_SERVER_ARCHITECTURE").StringVar(&app.vmParams.Architecture)
 	prepareCmd.Flag("vm.location", "vm location").Envar("CUSTOM_ENV_HCLOUD_SERVER_LOCATION").Default("fsn1").StringVar(&app.vmParams.Location)
+	prepareCmd.Flag("vm.fallback-locations", "locations used for further attempts, separated by ','").Envar("CUSTOM_ENV_HCLOUD_SERVER_FALLBACK_LOCATIONS").StringVar(&app.vmParams.FallbackLocations)
 
-	cleanupCmd := kingpinApp.Command("cleanup", "cleanup the environment").PreAction(app.prepareClient).Action(app.cleanup)
-	cleanupCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").Required().StringVar(&app.hcloudToken)
+	cleanupCmd := kingpinApp.Command("cleanup", "cleanup the environment").PreAction(app.prepareStateStore).PreAction(app.prepareProvider).Action(app.cleanup)
+	cleanupCmd.Flag("provider", "provider of the job machines").Envar("HMP_PROVIDER").Default(hetzner.Name).EnumVar(&app.providerName, hetzner.Name, static.Name)
+	cleanupCmd.Flag("static-hosts", "hosts used by the static provider as host[:port], separated by ','").Envar("HMP_STATIC_HOSTS").StringVar(&app.staticHosts)
+	cleanupCmd.Flag("static-hosts-file", "file listing hosts used by the static provider, one per line").Envar("HMP_STATIC_HOSTS_FILE").StringVar(&app.staticHostsFile)
+	cleanupCmd.Flag("static-lease-dir", "directory of the static host leases").Envar("HMP_STATIC_LEASE_DIR").Default(filepath.Join(helper.DefaultStateDir(), "leases")).StringVar(&app.staticOptions.LeaseDir)
+	cleanupCmd.Flag("static-reset-script", "script run on a static host to reset it after the job").Envar("HMP_STATIC_RESET_SCRIPT").StringVar(&app.staticOptions.ResetScript)
+	cleanupCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").StringVar(&app.hcloudToken)
 	cleanupCmd.Flag("job-id", "job id").Envar("CI_JOB_ID").Envar("CUSTOM_ENV_CI_JOB_ID").Required().StringVar(&app.jobID)
 
-	execCmd := kingpinApp.Command("exec", "execute a command").Action(app.exec)
+	execCmd := kingpinApp.Command("exec", "execute a command").PreAction(app.prepareStateStore).Action(app.exec)
+	execCmd.Flag("job-id", "job id").Envar("CI_JOB_ID").Envar("CUSTOM_ENV_CI_JOB_ID").Required().StringVar(&app.jobID)
 	execCmd.Arg("scriptPath", "script to execute").Required().StringVar(&app.execScriptPath)
 	execCmd.Arg("stageName", "stage name").Required().StringVar(&app.execStageName)
 
-	kingpinApp.Command("configure", "configure the environment").Action(app.configure)
+	configureCmd := kingpinApp.Command("configure", "configure the environment").PreAction(app.checkStateSecret).Action(app.configure)
+	configureCmd.Flag("prepare.ssh-key-source", "source of the ssh key; 'generate' creates a new key pair per job, 'agent' uses a key held by an ssh-agent").Envar("HMP_SSH_KEY_SOURCE").Default(actions.SSHKeySourceGenerate).EnumVar(&app.prepareOptions.SSHKeySource, actions.SSHKeySourceGenerate, actions.SSHKeySourceAgent)
+
+	stateCmd := kingpinApp.Command("state", "inspect and remove job states").PreAction(app.prepareStateStore)
+	stateCmd.Command("list", "list all job states").Action(app.stateList)
+	stateShowCmd := stateCmd.Command("show", "show the state of a job").Action(app.stateShow)
+	stateShowCmd.Arg("job-id", "job id").Required().StringVar(&app.jobID)
+	stateShowCmd.Flag("show-secrets", "do not redact the ssh private key").BoolVar(&app.showSecrets)
+	stateRemoveCmd := stateCmd.Command("rm", "remove the state of a job").Action(app.stateRemove)
+	stateRemoveCmd.Arg("job-id", "job id").Required().StringVar(&app.jobID)
+
+	gcCmd := kingpinApp.Command("gc", "remove left behind resources which are not bound to a single job, like empty placement groups").PreAction(app.prepareClient).Action(app.gc)
+	gcCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").Required().StringVar(&app.hcloudToken)
+
+	imageCmd := kingpinApp.Command("image", "manage snapshots to run jobs on")
+	imageBuildCmd := imageCmd.Command("build", "provision a server from a base image and create a snapshot of it, labeled hmp-image=<name>,version=<version>").PreAction(app.prepareClient).Action(app.imageBuild)
+	imageBuildCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").Required().StringVar(&app.hcloudToken)
+	imageBuildCmd.Flag("name", "image name").Required().StringVar(&app.imageBuildOptions.Name)
+	imageBuildCmd.Flag("image-version", "image version; defaults to the highest numeric version of the image plus one").StringVar(&app.imageBuildOptions.Version)
+	imageBuildCmd.Flag("base-image", "image to provision").Default("ubuntu-24.04").StringVar(&app.imageBuildOptions.BaseImage)
+	imageBuildCmd.Flag("type", "server type to build on").Default("auto").StringVar(&app.imageBuildOptions.ServerType)
+	imageBuildCmd.Flag("architecture", "server architecture to build for (amd64, arm64)").Default("amd64").StringVar(&app.imageBuildOptions.Architecture)
+	imageBuildCmd.Flag("location", "server location").Default("fsn1").StringVar(&app.imageBuildOptions.Location)
+	imageBuildCmd.Flag("wait-deadline", "maximum time to wait for the server to be ready").Default("10m").DurationVar(&app.imageBuildOptions.WaitDeadline)
+	imageBuildCmd.Arg("script", "provisioning script, run as root on the build server").Required().ExistingFileVar(&app.imageBuildOptions.Script)
+
+	imagePruneCmd := imageCmd.Command("prune", "delete old snapshots; the latest ones and the ones used by job servers are kept").PreAction(app.prepareClient).Action(app.imagePrune)
+	imagePruneCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").Required().StringVar(&app.hcloudToken)
+	imagePruneCmd.Flag("selector", "label selector of the snapshots, e.g. hmp-image=node").Required().StringVar(&app.imagePruneOptions.Selector)
+	imagePruneCmd.Flag("keep", "number of latest snapshots to keep regardless of their age").Default("3").IntVar(&app.imagePruneOptions.Keep)
+	imagePruneCmd.Flag("max-age", "age from which snapshots are deleted, e.g. 30d; 0 deletes them regardless of their age").Required().StringVar(&app.imagePruneMaxAge)
+	imagePruneCmd.Flag("dry-run", "only print the snapshots which would be deleted").BoolVar(&app.imagePruneOptions.DryRun)
+
+	cacheCmd := kingpinApp.Command("cache", "manage the metadata cache")
+	cacheRefreshCmd := cacheCmd.Command("refresh", "fetch server types, datacenters and system images again").PreAction(app.prepareClient).Action(app.cacheRefresh)
+	cacheRefreshCmd.Flag("hcloud-token", "hcloud token").Envar("HCLOUD_TOKEN").Required().StringVar(&app.hcloudToken)
+	cacheCmd.Command("clear", "remove all cached metadata").Action(app.cacheClear)
 
 	_, err := kingpinApp.Parse(os.Args[1:])
 	if err != nil {
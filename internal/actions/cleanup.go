@@ -2,26 +2,16 @@ package actions
 
 import (
 	"context"
-	"fmt"
-	"os"
-
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 
 	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
 )
 
-func Cleanup(client *hcloud.Client, jobID string) error {
-	server, _, getServerError := client.Server.GetByName(context.Background(), helper.ResourceName(jobID))
-	if getServerError != nil {
-		return getServerError
-	}
-	if server == nil {
-		return fmt.Errorf("server is not found")
+// Cleanup deletes everything provisioned for the job and its state; it succeeds if nothing is left. The state is
+// kept while anything is left, as it might be needed to remove it, e.g. to reset a static host.
+func Cleanup(machineProvider provider.Provider, store *helper.StateStore, jobID string) error {
+	if deleteError := machineProvider.Delete(context.Background(), jobID); deleteError != nil {
+		return deleteError
 	}
-
-	if _, _, err := client.Server.DeleteWithResult(context.Background(), server); err != nil {
-		return err
-	}
-
-	return os.Remove(helper.StatePath)
+	return store.Remove(jobID)
 }
@@ -0,0 +1,62 @@
+package actions
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakessh"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider/static"
+)
+
+// TestCleanupFailedReset checks that a static host which failed to reset stays leased on repeated cleanups
+func TestCleanupFailedReset(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	assert.NoError(t, err)
+	privateKeyBlock, err := ssh.MarshalPrivateKey(privateKey, "")
+	assert.NoError(t, err)
+	server, err := fakessh.Start(fakessh.Options{AuthorizedKeys: []ssh.PublicKey{signer.PublicKey()}})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	scriptPath := filepath.Join(t.TempDir(), "reset.sh")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte("exit 1"), 0o600))
+	store := &helper.StateStore{Backend: &helper.LocalStateBackend{BaseDir: t.TempDir()}, Secret: "secret"}
+	leaseDir := t.TempDir()
+	staticProvider, err := static.New(static.Options{
+		Hosts:        []static.Host{{Address: server.Host(), Port: server.Port()}},
+		LeaseDir:     leaseDir,
+		LeaseTimeout: time.Second,
+		ResetScript:  scriptPath,
+		Store:        store,
+	})
+	assert.NoError(t, err)
+
+	_, err = staticProvider.Create(context.Background(), provider.Spec{JobID: "1234"}, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Write("1234", &helper.State{
+		SSHPrivateKey: string(pem.EncodeToMemory(privateKeyBlock)),
+		ServerAddress: server.Host(),
+		SSHPort:       server.Port(),
+	}))
+
+	for range 2 {
+		assert.Error(t, Cleanup(staticProvider, store, "1234"))
+		_, readError := store.Read("1234")
+		assert.NoError(t, readError)
+		leases, globError := filepath.Glob(filepath.Join(leaseDir, "*.lease"))
+		assert.NoError(t, globError)
+		assert.Len(t, leases, 1)
+	}
+}
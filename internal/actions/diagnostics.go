@@ -0,0 +1,113 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+const (
+	diagnosticsTimeout = 1 * time.Minute
+	defaultSSHPort     = 22
+	diagnosticsCommand = "cloud-init status --long; tail -n 100 /var/log/cloud-init-output.log"
+)
+
+// collectDiagnostics gathers information about a machine which did not become ready.
+// The diagnostics are printed to the job log and written to a file in diagnosticsDir, if set. If the machine is not
+// reachable via ssh, a screenshot of its console is saved next to it, as the console is gone after the rollback.
+func collectDiagnostics(machineProvider provider.Provider, machine *provider.Machine, signer ssh.Signer, jobID, diagnosticsDir string) {
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+
+	fmt.Println("🩺 Collect diagnostics")
+	report := &bytes.Buffer{}
+
+	machineProvider.Describe(ctx, report, machine)
+
+	sshDiagnosticsError := writeSSHDiagnostics(ctx, report, signer, machine.Address, machine.SSHPort)
+	if sshDiagnosticsError != nil {
+		fmt.Fprintf(report, "cloud-init logs: not available via ssh: %s\n", sshDiagnosticsError)
+	}
+
+	if consoler, hasConsole := machineProvider.(provider.Consoler); hasConsole && sshDiagnosticsError != nil {
+		writeConsoleDiagnostics(ctx, report, consoler, machine, jobID, diagnosticsDir)
+	}
+
+	fmt.Print(helper.IndentLines(report.String()))
+
+	if diagnosticsDir == "" {
+		return
+	}
+	diagnosticsPath := filepath.Join(diagnosticsDir, jobID+".log")
+	if mkdirError := os.MkdirAll(diagnosticsDir, 0700); mkdirError != nil {
+		fmt.Printf("\t\t⚠️ Cannot save diagnostics: %s\n", mkdirError)
+		return
+	}
+	if writeError := helper.WriteFileAtomic(diagnosticsPath, report.Bytes(), 0600); writeError != nil {
+		fmt.Printf("\t\t⚠️ Cannot save diagnostics: %s\n", writeError)
+		return
+	}
+	fmt.Printf("\t\tDiagnostics saved to %s\n", diagnosticsPath)
+}
+
+// writeConsoleDiagnostics captures the console screen and saves it as png to diagnosticsDir; the report only
+// contains the path of the screenshot, never the console credentials
+func writeConsoleDiagnostics(ctx context.Context, report io.Writer, consoler provider.Consoler, machine *provider.Machine, jobID, diagnosticsDir string) {
+	screen, captureError := consoler.CaptureConsole(ctx, machine)
+	if captureError != nil {
+		fmt.Fprintf(report, "console: cannot capture screen: %s\n", captureError)
+		return
+	}
+	if diagnosticsDir == "" {
+		fmt.Fprintln(report, "console: screen captured, but no diagnostics directory is configured to save it to")
+		return
+	}
+
+	screenshot := &bytes.Buffer{}
+	if encodeError := png.Encode(screenshot, screen); encodeError != nil {
+		fmt.Fprintf(report, "console: cannot encode screenshot: %s\n", encodeError)
+		return
+	}
+	screenshotPath := filepath.Join(diagnosticsDir, jobID+"-console.png")
+	saveError := os.MkdirAll(diagnosticsDir, 0700)
+	if saveError == nil {
+		saveError = helper.WriteFileAtomic(screenshotPath, screenshot.Bytes(), 0600)
+	}
+	if saveError != nil {
+		fmt.Fprintf(report, "console: cannot save screenshot: %s\n", saveError)
+		return
+	}
+	fmt.Fprintf(report, "console: screenshot saved to %s\n", screenshotPath)
+}
+
+// writeSSHDiagnostics writes the cloud-init status and logs; the default ssh port is tried as well,
+// as the custom port is configured by cloud-init itself
+func writeSSHDiagnostics(ctx context.Context, report io.Writer, signer ssh.Signer, serverAddress string, sshPort uint16) error {
+	var connectErrors []string
+	for _, port := range []uint16{sshPort, defaultSSHPort} {
+		sshClient, sshClientError := helper.NewSSHClient(signer, serverAddress, port)
+		if sshClientError != nil {
+			connectErrors = append(connectErrors, fmt.Sprintf("port %d: %s", port, sshClientError))
+			continue
+		}
+		output, _ := sshClient.RunCommandOutput(ctx, diagnosticsCommand)
+		sshClient.Close()
+
+		fmt.Fprintf(report, "cloud-init (via ssh port %d):\n%s\n", port, strings.TrimRight(string(output), "\n"))
+		return nil
+	}
+
+	return errors.New(strings.Join(connectErrors, "; "))
+}
@@ -0,0 +1,81 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+// consoleProvider is a provider whose machines are only reachable through their console
+type consoleProvider struct {
+	provider.Provider
+	captureError error
+}
+
+func (p *consoleProvider) Describe(_ context.Context, report io.Writer, machine *provider.Machine) {
+	_, _ = io.WriteString(report, "server: "+machine.ID+"\n")
+}
+
+func (p *consoleProvider) CaptureConsole(context.Context, *provider.Machine) (image.Image, error) {
+	return image.NewRGBA(image.Rect(0, 0, 4, 3)), p.captureError
+}
+
+func TestWriteConsoleDiagnostics(t *testing.T) {
+	for _, testCase := range []struct {
+		name               string
+		captureError       error
+		diagnosticsDir     bool
+		expectedReport     string
+		expectedScreenshot bool
+	}{
+		{"saved", nil, true, "console: screenshot saved to ", true},
+		{"no diagnostics dir", nil, false, "console: screen captured, but no diagnostics directory is configured", false},
+		{"capture failed", errors.New("console unavailable"), true, "console: cannot capture screen: console unavailable", false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			diagnosticsDir := ""
+			if testCase.diagnosticsDir {
+				diagnosticsDir = filepath.Join(t.TempDir(), "diagnostics")
+			}
+			report := &bytes.Buffer{}
+			writeConsoleDiagnostics(context.Background(), report, &consoleProvider{captureError: testCase.captureError}, &provider.Machine{ID: "1"}, "1234", diagnosticsDir)
+			assert.Contains(t, report.String(), testCase.expectedReport)
+
+			screenshot, readError := os.ReadFile(filepath.Join(diagnosticsDir, "1234-console.png"))
+			assert.Equal(t, testCase.expectedScreenshot, readError == nil)
+			if readError == nil {
+				config, decodeError := png.DecodeConfig(bytes.NewReader(screenshot))
+				assert.NoError(t, decodeError)
+				assert.Equal(t, 4, config.Width)
+			}
+		})
+	}
+}
+
+func TestCollectDiagnosticsConsoleFallback(t *testing.T) {
+	diagnosticsDir := t.TempDir()
+	// nothing listens on the machine address, so the console is captured instead
+	machine := &provider.Machine{ID: "1", Address: "127.0.0.2", SSHPort: 2222}
+	privateKey, _, err := helper.GenerateSSHKeyPair(helper.SSHKeyTypeED25519)
+	assert.NoError(t, err)
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	assert.NoError(t, err)
+	collectDiagnostics(&consoleProvider{}, machine, signer, "1234", diagnosticsDir)
+
+	report, readError := os.ReadFile(filepath.Join(diagnosticsDir, "1234.log"))
+	assert.NoError(t, readError)
+	assert.Contains(t, string(report), "console: screenshot saved to "+filepath.Join(diagnosticsDir, "1234-console.png"))
+	assert.FileExists(t, filepath.Join(diagnosticsDir, "1234-console.png"))
+}
@@ -11,19 +11,24 @@ import (
 	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
 )
 
-func Exec(cmdFile, stageName string) error {
-	state, readStateError := helper.ReadStateFromFile(helper.StatePath)
+func Exec(store *helper.StateStore, jobID, cmdFile, stageName string) error {
+	state, readStateError := store.Read(jobID)
 	if readStateError != nil {
 		return readStateError
 	}
 
-	if state.SSHPrivateKey == "" || state.ServerAddress == "" {
+	if state.ServerAddress == "" {
 		return fmt.Errorf("incomplete state")
 	}
 
+	signer, signerError := state.Signer()
+	if signerError != nil {
+		return signerError
+	}
+
 	waitDeadlineContext, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
-	if err := helper.WaitReachable(waitDeadlineContext, state.SSHPrivateKey, state.ServerAddress); err != nil {
+	if err := helper.WaitReachable(waitDeadlineContext, signer, state.ServerAddress, state.Port(), helper.SSHRetryDelay); err != nil {
 		return err
 	}
 
@@ -32,7 +37,7 @@ func Exec(cmdFile, stageName string) error {
 	clientConnectError := retry.Do(
 		func() error {
 			var sshClientError error
-			sshClient, sshClientError = helper.NewSSHClient(state.SSHPrivateKey, state.ServerAddress, helper.CustomSSHPort)
+			sshClient, sshClientError = helper.NewSSHClient(signer, state.ServerAddress, state.Port())
 			return sshClientError
 		},
 		retry.Attempts(3),
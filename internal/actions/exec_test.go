@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakessh"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider/hetzner"
+)
+
+// sshPortProvider moves the ssh port of the created machines to the fake ssh server
+type sshPortProvider struct {
+	provider.Provider
+	sshPort uint16
+}
+
+func (p *sshPortProvider) Create(ctx context.Context, spec provider.Spec, machineType *provider.Type, image *provider.Image) (*provider.Machine, error) {
+	machine, createError := p.Provider.Create(ctx, spec, machineType, image)
+	if machine != nil {
+		machine.SSHPort = p.sshPort
+	}
+	return machine, createError
+}
+
+// TestPrepareExecCleanup runs a whole job against the fake hcloud api, with a fake ssh server standing in for the job server
+func TestPrepareExecCleanup(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	// the job server authorizes the ssh keys injected on creation
+	server, err := fakessh.Start(fakessh.Options{
+		Authorize: func(key ssh.PublicKey) bool {
+			for _, publicKey := range api.AuthorizedKeys(helper.ResourceName("1234")) {
+				authorizedKey, _, _, _, parseError := ssh.ParseAuthorizedKey([]byte(publicKey))
+				if parseError == nil && bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+	})
+	assert.NoError(t, err)
+	defer server.Close()
+	api.ServerIP = server.Host()
+
+	hetznerProvider := &sshPortProvider{Provider: hetzner.New(hetzner.NewClient(api.Client()), hetzner.Options{}), sshPort: server.Port()}
+	store := &helper.StateStore{Backend: &helper.LocalStateBackend{BaseDir: t.TempDir()}, Secret: "secret"}
+
+	assert.NoError(t, Prepare(hetznerProvider, store, PrepareOptions{
+		JobID:        "1234",
+		WaitDeadline: 5 * time.Second,
+		SSHKeyType:   helper.SSHKeyTypeED25519,
+		SSHKeySource: SSHKeySourceGenerate,
+		Attempts:     1,
+	}, VMParams{Image: "ubuntu-24.04", Type: "auto", Architecture: "amd64", Location: "fsn1"}))
+	assert.Len(t, api.Servers(), 1)
+	// the ssh key is only needed while creating the server
+	assert.Empty(t, api.SSHKeys())
+
+	state, err := store.Read("1234")
+	assert.NoError(t, err)
+	assert.Equal(t, server.Port(), state.Port())
+
+	for _, testCase := range []struct {
+		name          string
+		script        string
+		expectedError bool
+	}{
+		{"success", "echo build > build.log", false},
+		{"failure", "echo test >> build.log; exit 1", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			scriptPath := filepath.Join(t.TempDir(), "script")
+			assert.NoError(t, os.WriteFile(scriptPath, []byte(testCase.script), 0o600))
+
+			execError := Exec(store, "1234", scriptPath, "build_script")
+			assert.Equal(t, testCase.expectedError, execError != nil)
+		})
+	}
+	buildLog, err := os.ReadFile(filepath.Join(server.Dir, "build.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "build\ntest\n", string(buildLog))
+
+	assert.NoError(t, Cleanup(hetznerProvider, store, "1234"))
+	assert.Empty(t, api.Servers())
+	_, err = store.Read("1234")
+	assert.Error(t, err)
+}
@@ -1,287 +1,204 @@
 package actions
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"regexp"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/hetznercloud/hcloud-go/v2/hcloud"
 	"golang.org/x/crypto/ssh"
 
-	"github.com/bonsai-oss/hetzner-machine-provider/assets"
 	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
 )
 
-const labelSelectorPrefix = "label#"
-const latestImageSuffix = ":latest"
+// errServerNotReady signals that the server has been created, but did not become ready in time
+var errServerNotReady = errors.New("server did not become ready")
+
+const (
+	SSHKeySourceGenerate = "generate"
+	SSHKeySourceAgent    = "agent"
+)
 
 type VMParams struct {
 	Image        string
 	Type         string
 	Location     string
 	Architecture string
+	// FallbackLocations are used for further attempts, separated by ','
+	FallbackLocations string
 }
 
 type PrepareOptions struct {
-	JobID                    string
-	WaitDeadline             time.Duration
-	AdditionalAuthorizedKeys string
+	JobID        string
+	WaitDeadline time.Duration
+
+	SSHKeyType     helper.SSHKeyType
+	SSHKeySource   string
+	SSHAgentSocket string
+	SSHAgentKey    string
+
+	DiagnosticsDir string
+	// KeepOnFailure keeps all created resources for debugging instead of rolling them back
+	KeepOnFailure bool
+	// Attempts is the number of servers created at most, if they do not become ready
+	Attempts int
 }
 
-func Prepare(client *hcloud.Client, options PrepareOptions, params VMParams) error {
-	privateKey, pub, generateSSHKeyError := helper.GenerateSSHKeyPair()
-	if generateSSHKeyError != nil {
-		return generateSSHKeyError
-	}
+func Prepare(machineProvider provider.Provider, store *helper.StateStore, options PrepareOptions, params VMParams) (prepareError error) {
+	// gitlab does not reliably run cleanup if prepare fails, so everything created so far is removed on failure
+	rollback := &helper.Rollback{}
+	defer func() {
+		if prepareError == nil {
+			return
+		}
+		if options.KeepOnFailure {
+			fmt.Println("⚠️ Keep created resources for debugging, run cleanup to remove them")
+			if _, readStateError := store.Read(options.JobID); readStateError == nil {
+				fmt.Printf("\t\tShow the ssh private key using: hmp state show --show-secrets %s\n", options.JobID)
+			}
+			return
+		}
+		fmt.Println("🧹 Roll back created resources")
+		if rollbackError := rollbackMachine(rollback, store, options.JobID); rollbackError != nil {
+			fmt.Printf("\t\t⚠️ Rollback failed: %s\n", rollbackError)
+		}
+	}()
+
+	state, signer, sshCredentialsError := prepareSSHCredentials(options)
+	if sshCredentialsError != nil {
+		return sshCredentialsError
+	}
+	fmt.Printf("\t\tFingerprint: %+v\n\n", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	locations := attemptLocations(params)
+	attempts := max(options.Attempts, 1)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		spec := provider.Spec{
+			JobID:        options.JobID,
+			Image:        params.Image,
+			Type:         params.Type,
+			Architecture: params.Architecture,
+			Location:     locations[(attempt-1)%len(locations)],
+			PublicKey:    signer.PublicKey(),
+			Rollback:     rollback,
+		}
+		if attempts > 1 {
+			fmt.Printf("📠 Create CI server (attempt %d/%d in %s)\n", attempt, attempts, spec.Location)
+		} else {
+			fmt.Println("📠 Create CI server")
+		}
 
-	fmt.Println("🔐 Create SSH key pair")
-	if pk, pkParseError := ssh.ParsePrivateKey([]byte(privateKey)); pkParseError != nil {
-		return pkParseError
-	} else {
-		fmt.Printf("\t\tFingerprint: %+v\n\n", ssh.FingerprintLegacyMD5(pk.PublicKey()))
-	}
+		prepareMachineError := prepareMachine(machineProvider, store, state, options, spec, signer, options.WaitDeadline/time.Duration(attempts))
+		if prepareMachineError == nil {
+			return nil
+		}
+		if !errors.Is(prepareMachineError, errServerNotReady) || attempt == attempts {
+			return prepareMachineError
+		}
 
-	// Create SSH key
-	hcloudSSHKey, _, keyCreateError := client.SSHKey.Create(context.Background(), hcloud.SSHKeyCreateOpts{
-		Name:      helper.ResourceName(options.JobID),
-		PublicKey: pub,
-		Labels: map[string]string{
-			"managed-by": "hmp",
-		},
-	})
-	if keyCreateError != nil {
-		return keyCreateError
+		fmt.Println("🔁 Replace server which did not become ready")
+		if rollbackError := rollbackMachine(rollback, store, options.JobID); rollbackError != nil {
+			return rollbackError
+		}
 	}
-	defer client.SSHKey.Delete(context.Background(), hcloudSSHKey)
 
-	fmt.Println("📠 Create CI server")
-	// Assign server labels from environment variables
-	labels := map[string]string{"managed-by": "hmp"}
-	assignLabels(labels, map[string]string{
-		"commit-ref":  "CUSTOM_ENV_CI_COMMIT_REF_NAME",
-		"commit-sha":  "CUSTOM_ENV_CI_COMMIT_SHA",
-		"job-id":      "CUSTOM_ENV_CI_JOB_ID",
-		"pipeline-id": "CUSTOM_ENV_CI_PIPELINE_ID",
-		"project-id":  "CUSTOM_ENV_CI_PROJECT_ID",
-		"tag":         "CUSTOM_ENV_CI_COMMIT_TAG",
-	})
+	return fmt.Errorf("no server prepared")
+}
 
-	var serverType *hcloud.ServerType
-	var serverTypeGetError error
-	if params.Type == "auto" {
-		serverType, serverTypeGetError = automaticServerSelection(client, params.Architecture, params.Location)
-	} else {
-		serverType, _, serverTypeGetError = client.ServerType.GetByName(context.Background(), params.Type)
-	}
-	if serverTypeGetError != nil {
-		fmt.Print("❌ Cannot determine server details: ")
-		return serverTypeGetError
+// rollbackMachine removes the resources created for the job in reverse order; the state is kept while anything is
+// left, as it might be needed to remove it, e.g. to reset a static host
+func rollbackMachine(rollback *helper.Rollback, store *helper.StateStore, jobID string) error {
+	if rollbackError := rollback.Run(context.Background()); rollbackError != nil {
+		return rollbackError
 	}
+	return store.Remove(jobID)
+}
 
-	// if the image selector starts with "l#", it is a label selector; prepare the list options accordingly
-	listOptions := hcloud.ListOpts{}
-	if isLabelSelector(params.Image) {
-		listOptions.LabelSelector = strings.TrimPrefix(params.Image, labelSelectorPrefix)
-	}
-	images, _, imageListError := client.Image.List(context.Background(), hcloud.ImageListOpts{
-		Type:         []hcloud.ImageType{hcloud.ImageTypeSnapshot, hcloud.ImageTypeSystem},
-		Status:       []hcloud.ImageStatus{hcloud.ImageStatusAvailable},
-		Architecture: []hcloud.Architecture{serverType.Architecture},
-		ListOpts:     listOptions,
-	})
-	if imageListError != nil {
-		return imageListError
-	}
+// prepareMachine creates a machine for the spec and waits until it is ready. The state is written as soon as the
+// machine exists, so its credentials are available to debug and clean it up if it does not become ready.
+func prepareMachine(machineProvider provider.Provider, store *helper.StateStore, state *helper.State, options PrepareOptions, spec provider.Spec, signer ssh.Signer, waitDeadline time.Duration) error {
+	ctx := context.Background()
 
-	image, imageSelectionError := imageSelection(images, params.Image)
-	if imageSelectionError != nil {
-		return imageSelectionError
+	machineType, resolveTypeError := machineProvider.ResolveType(ctx, spec)
+	if resolveTypeError != nil {
+		fmt.Print("❌ Cannot determine server details: ")
+		return resolveTypeError
 	}
-
-	imageDisplayName := image.Name
-	if image.Name == "" {
-		imageDisplayName = fmt.Sprintf("id=%d", image.ID)
+	image, resolveImageError := machineProvider.ResolveImage(ctx, spec, machineType)
+	if resolveImageError != nil {
+		return resolveImageError
 	}
 
 	fmt.Printf(
 		"\t\tType:  %+v [%s]\n\t\tImage: %+v\n",
-		serverType.Description,
-		determineArchitectureString(serverType.Architecture),
-		imageDisplayName,
+		machineType.Description,
+		machineType.Architecture,
+		image.DisplayName(),
 	)
 
-	userDataBuffer := &bytes.Buffer{}
-	userData := map[string]any{
-		"ssh_authorized_keys": strings.Split(options.AdditionalAuthorizedKeys, "\n"),
-		"architecture":        determineArchitectureString(serverType.Architecture),
+	machine, createError := machineProvider.Create(ctx, spec, machineType, image)
+	if createError != nil {
+		return createError
 	}
-	if userdataRenderError := assets.CloudInitTemplate.Execute(userDataBuffer, userData); userdataRenderError != nil {
-		return userdataRenderError
+	state.ServerAddress = machine.Address
+	state.SSHPort = machine.SSHPort
+	if writeStateError := store.Write(options.JobID, state); writeStateError != nil {
+		return writeStateError
 	}
 
-	createResult, _, serverCreateError := client.Server.Create(context.Background(), hcloud.ServerCreateOpts{
-		Name:       helper.ResourceName(options.JobID),
-		ServerType: serverType,
-		Labels:     labels,
-		SSHKeys: []*hcloud.SSHKey{
-			hcloudSSHKey,
-		},
-		Location: &hcloud.Location{
-			Name: params.Location,
-		},
-		Image:    image,
-		UserData: userDataBuffer.String(),
-	})
-	if serverCreateError != nil {
-		fmt.Println("❌ Server creation failed")
-		return serverCreateError
-	}
+	fmt.Printf("⏳ Waiting %s for server to be ready\n", waitDeadline)
 
-	if createResult.Server == nil {
-		fmt.Println("❌ Server creation failed")
-		return fmt.Errorf("server is not found")
-	}
-
-	fmt.Printf("⏳ Waiting %s for server to be ready\n", options.WaitDeadline)
-
-	waitDeadlineContext, cancel := context.WithTimeout(context.Background(), options.WaitDeadline)
+	waitDeadlineContext, cancel := context.WithTimeout(ctx, waitDeadline)
 	defer cancel()
-	if waitReachableError := helper.WaitReachable(waitDeadlineContext, privateKey, createResult.Server.PublicNet.IPv4.IP.String()); waitReachableError != nil {
-		return waitReachableError
+	if waitReadyError := machineProvider.Wait(waitDeadlineContext, machine, signer); waitReadyError != nil {
+		collectDiagnostics(machineProvider, machine, signer, options.JobID, options.DiagnosticsDir)
+		return fmt.Errorf("%w: %w", errServerNotReady, waitReadyError)
 	}
-	fmt.Println("✅ Server created, took", time.Since(createResult.Server.Created).Round(time.Second))
+	fmt.Println("✅ Server created, took", time.Since(machine.Created).Round(time.Second))
 
-	state := helper.State{
-		ServerAddress: createResult.Server.PublicNet.IPv4.IP.String(),
-		SSHPrivateKey: privateKey,
-	}
-
-	return state.WriteToFile(helper.StatePath)
+	return nil
 }
 
-func determineArchitectureString(serverArchitecture hcloud.Architecture) string {
-	switch serverArchitecture {
-	case hcloud.ArchitectureX86:
-		return "amd64"
-	case hcloud.ArchitectureARM:
-		return "arm64"
-	}
-
-	return "amd64"
-}
-
-// getServerClassification determines server classification by server type name to be sortable (e.g. "cx11" -> "11")
-func getServerClassification(server string) string {
-	return regexp.MustCompile(`\d+`).FindString(server)
-}
-
-// getAvailableServerTypesByLocation determines datacenters in provided location and get available server types
-func getAvailableServerTypesByLocation(client *hcloud.Client, locationName string) ([]*hcloud.ServerType, error) {
-	datacenters, _, fetchDatacentersError := client.Datacenter.List(context.Background(), hcloud.DatacenterListOpts{})
-	if fetchDatacentersError != nil {
-		return nil, fetchDatacentersError
-	}
-	datacenters = helper.Filter(datacenters, func(datacenter *hcloud.Datacenter) bool {
-		return datacenter.Location.Name == locationName
-	})
-	if len(datacenters) == 0 || datacenters == nil {
-		return nil, fmt.Errorf("no datacenters found for location %s", locationName)
-	}
-
-	datacenter := datacenters[0]
-	if len(datacenter.ServerTypes.Available) == 0 {
-		return nil, fmt.Errorf("no server types available in %s", locationName)
-	}
-
-	return helper.Map(datacenter.ServerTypes.Available, func(availableServerType *hcloud.ServerType) *hcloud.ServerType {
-		serverType, _, getServerTypeError := client.ServerType.GetByID(context.Background(), availableServerType.ID)
-		if getServerTypeError != nil {
-			return nil
+// attemptLocations returns the location followed by the fallback locations
+func attemptLocations(params VMParams) []string {
+	locations := []string{params.Location}
+	for _, location := range strings.Split(params.FallbackLocations, ",") {
+		if location = strings.TrimSpace(location); location != "" && location != params.Location {
+			locations = append(locations, location)
 		}
-		return serverType
-	}), nil
-}
-
-// imageSelection selects an image based on the image selector
-func imageSelection(images []*hcloud.Image, imageSelector string) (*hcloud.Image, error) {
-	var filteredImages []*hcloud.Image
-
-	if isLabelSelector(imageSelector) {
-		sort.SliceStable(images, func(i, j int) bool {
-			return images[i].Created.After(images[j].Created)
-		})
-		filteredImages = images
-	} else if strings.HasSuffix(imageSelector, latestImageSuffix) {
-		imageSelector := strings.TrimSuffix(imageSelector, latestImageSuffix)
-		filteredImages = helper.Filter(images, func(image *hcloud.Image) bool {
-			return strings.Contains(image.Name, imageSelector)
-		})
-		sort.SliceStable(filteredImages, func(i, j int) bool {
-			return filteredImages[i].OSVersion > filteredImages[j].OSVersion
-		})
-	} else {
-		filteredImages = helper.Filter(images, func(image *hcloud.Image) bool {
-			return image.Name == imageSelector
-		})
-	}
-
-	if len(filteredImages) == 0 {
-		return nil, fmt.Errorf("no images found for selector %+q", imageSelector)
 	}
-
-	return &hcloud.Image{
-		ID:   filteredImages[0].ID,
-		Name: filteredImages[0].Name,
-	}, nil
-}
-
-// isLabelSelector checks if the image selector is a label selector
-func isLabelSelector(imageSelector string) bool {
-	return strings.HasPrefix(imageSelector, labelSelectorPrefix)
-}
-
-// automaticServerSelection selects a server type based on the architecture and CPU type
-func automaticServerSelection(client *hcloud.Client, architecture string, location string) (*hcloud.ServerType, error) {
-	serverTypes, serverTypeListError := getAvailableServerTypesByLocation(client, location)
-	if serverTypeListError != nil {
-		return nil, serverTypeListError
-	}
-	// filter server types by architecture and CPU type
-	possibleServerTypes := helper.Filter(serverTypes, func(serverType *hcloud.ServerType) bool {
-		return determineArchitectureString(serverType.Architecture) == architecture && serverType.CPUType == hcloud.CPUTypeShared
-	})
-
-	if len(possibleServerTypes) == 0 {
-		return nil, fmt.Errorf("no server type found for architecture %+q", architecture)
-	}
-
-	// sort server types by classification (e.g. cx11, cx21, cx31, ...)
-	sort.SliceStable(possibleServerTypes, func(i, j int) bool {
-		return getServerClassification(possibleServerTypes[i].Name) > getServerClassification(possibleServerTypes[j].Name)
-	})
-	// get the server located in the middle of the list of possible server types
-	return possibleServerTypes[len(possibleServerTypes)/2], nil
+	return locations
 }
 
-// assignLabels assigns values from environment variables to server labels
-func assignLabels(labels map[string]string, labelEnvironmentVariableMapping map[string]string) {
-	for label, environmentVariable := range labelEnvironmentVariableMapping {
-		if value, variableIsSet := os.LookupEnv(environmentVariable); variableIsSet {
-			labelValid, labelValidationError := hcloud.ValidateResourceLabels(map[string]any{label: value})
-			if labelValidationError != nil {
-				fmt.Printf("\t\t⚠️ Label validation failed: %+q\n", labelValidationError)
-				continue
-			}
-			if !labelValid {
-				continue
-			}
+// prepareSSHCredentials either generates a new key pair or obtains the key from an ssh-agent
+func prepareSSHCredentials(options PrepareOptions) (*helper.State, ssh.Signer, error) {
+	state := &helper.State{}
 
-			labels[label] = value
+	switch options.SSHKeySource {
+	case SSHKeySourceAgent:
+		fmt.Println("🔐 Use SSH key from agent")
+		signer, agentSignerError := helper.AgentSigner(options.SSHAgentSocket, options.SSHAgentKey)
+		if agentSignerError != nil {
+			return nil, nil, agentSignerError
 		}
+		state.SSHAgentSocket = options.SSHAgentSocket
+		state.SSHKeyFingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+		return state, signer, nil
+	case SSHKeySourceGenerate, "":
+		fmt.Printf("🔐 Create SSH key pair (%s)\n", options.SSHKeyType)
+		privateKey, _, generateSSHKeyError := helper.GenerateSSHKeyPair(options.SSHKeyType)
+		if generateSSHKeyError != nil {
+			return nil, nil, generateSSHKeyError
+		}
+		signer, pkParseError := ssh.ParsePrivateKey([]byte(privateKey))
+		if pkParseError != nil {
+			return nil, nil, pkParseError
+		}
+		state.SSHPrivateKey = privateKey
+		return state, signer, nil
 	}
+
+	return nil, nil, fmt.Errorf("unsupported ssh key source %+q", options.SSHKeySource)
 }
@@ -0,0 +1,50 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+const redactedValue = "<redacted>"
+
+// StateList prints all persisted job states
+func StateList(store *helper.StateStore) error {
+	jobIDs, listError := store.List()
+	if listError != nil {
+		return listError
+	}
+
+	for _, jobID := range jobIDs {
+		serverAddress := "-"
+		if state, readError := store.Read(jobID); readError == nil {
+			serverAddress = state.ServerAddress
+		}
+		fmt.Printf("%s\t%s\n", jobID, serverAddress)
+	}
+
+	return nil
+}
+
+// StateShow prints the state of a job; the private key is redacted unless showSecrets is set
+func StateShow(store *helper.StateStore, jobID string, showSecrets bool) error {
+	state, readError := store.Read(jobID)
+	if readError != nil {
+		return readError
+	}
+
+	if !showSecrets && state.SSHPrivateKey != "" {
+		state.SSHPrivateKey = redactedValue
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
+
+// StateRemove deletes the state of a job
+func StateRemove(store *helper.StateStore, jobID string) error {
+	return store.Remove(jobID)
+}
@@ -0,0 +1,822 @@
+// Package fakehcloud implements an in-memory fake of the parts of the hcloud API used by hmp, so the actions can be
+// tested end to end without a token. Actions complete immediately, all other resources are kept in memory.
+package fakehcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+)
+
+// maxPlacementGroupServers is the number of servers a spread placement group can contain
+const maxPlacementGroupServers = 10
+
+// Server is a fake hcloud API served by an httptest.Server
+type Server struct {
+	*httptest.Server
+
+	// ServerIP is the public ipv4 address assigned to created servers
+	ServerIP string
+
+	mutex           sync.Mutex
+	nextID          int64
+	actions         map[int64]schema.Action
+	datacenters     []schema.Datacenter
+	serverTypes     []schema.ServerType
+	images          []schema.Image
+	placementGroups []schema.PlacementGroup
+	servers         []schema.Server
+	sshKeys         []schema.SSHKey
+	volumes         []schema.Volume
+	// authorizedKeys are the public keys injected into each server on creation, as done by cloud-init
+	authorizedKeys map[int64][]string
+	// userData is the user data of the servers by name; it is kept after the server has been deleted
+	userData map[string]string
+}
+
+// New starts a fake API with a default set of datacenters, server types and system images
+func New() *Server {
+	s := &Server{
+		ServerIP: "127.0.0.1",
+		nextID:   1000,
+		actions:  map[int64]schema.Action{},
+
+		authorizedKeys: map[int64][]string{},
+		userData:       map[string]string{},
+	}
+
+	for _, serverType := range []schema.ServerType{
+		{Name: "cx22", Description: "CX22", Cores: 2, Memory: 4, Disk: 40, CPUType: "shared", Architecture: "x86"},
+		{Name: "cx32", Description: "CX32", Cores: 4, Memory: 8, Disk: 80, CPUType: "shared", Architecture: "x86"},
+		{Name: "cx42", Description: "CX42", Cores: 8, Memory: 16, Disk: 160, CPUType: "shared", Architecture: "x86"},
+		{Name: "cax11", Description: "CAX11", Cores: 2, Memory: 4, Disk: 40, CPUType: "shared", Architecture: "arm"},
+		{Name: "ccx13", Description: "CCX13", Cores: 2, Memory: 8, Disk: 80, CPUType: "dedicated", Architecture: "x86"},
+	} {
+		s.AddServerType(serverType)
+	}
+	s.AddDatacenter("fsn1-dc14", "fsn1", "cx22", "cx32", "cx42", "cax11", "ccx13")
+	s.AddDatacenter("nbg1-dc3", "nbg1", "cx22", "cx32", "cx42", "cax11", "ccx13")
+	s.AddDatacenter("ash-dc1", "ash", "ccx13")
+	for _, architecture := range []string{"x86", "arm"} {
+		s.AddImage(schema.Image{Name: hcloud.Ptr("ubuntu-22.04"), Type: "system", OSFlavor: "ubuntu", OSVersion: hcloud.Ptr("22.04"), Architecture: architecture})
+		s.AddImage(schema.Image{Name: hcloud.Ptr("ubuntu-24.04"), Type: "system", OSFlavor: "ubuntu", OSVersion: hcloud.Ptr("24.04"), Architecture: architecture})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /actions", s.listActions)
+	mux.HandleFunc("GET /actions/{id}", s.getAction)
+	mux.HandleFunc("GET /datacenters", s.listDatacenters)
+	mux.HandleFunc("GET /server_types", s.listServerTypes)
+	mux.HandleFunc("GET /images", s.listImages)
+	mux.HandleFunc("GET /images/{id}", s.getImage)
+	mux.HandleFunc("DELETE /images/{id}", s.deleteImage)
+	mux.HandleFunc("GET /placement_groups", s.listPlacementGroups)
+	mux.HandleFunc("POST /placement_groups", s.createPlacementGroup)
+	mux.HandleFunc("DELETE /placement_groups/{id}", s.deletePlacementGroup)
+	mux.HandleFunc("GET /ssh_keys", s.listSSHKeys)
+	mux.HandleFunc("POST /ssh_keys", s.createSSHKey)
+	mux.HandleFunc("DELETE /ssh_keys/{id}", s.deleteSSHKey)
+	mux.HandleFunc("GET /servers", s.listServers)
+	mux.HandleFunc("POST /servers", s.createServer)
+	mux.HandleFunc("GET /servers/{id}", s.getServer)
+	mux.HandleFunc("DELETE /servers/{id}", s.deleteServer)
+	mux.HandleFunc("POST /servers/{id}/actions/request_console", s.requestConsole)
+	mux.HandleFunc("POST /servers/{id}/actions/shutdown", s.shutdownServer)
+	mux.HandleFunc("POST /servers/{id}/actions/create_image", s.createServerImage)
+	mux.HandleFunc("GET /volumes", s.listVolumes)
+	mux.HandleFunc("POST /volumes", s.createVolume)
+	mux.HandleFunc("GET /volumes/{id}", s.getVolume)
+	mux.HandleFunc("PUT /volumes/{id}", s.updateVolume)
+	mux.HandleFunc("DELETE /volumes/{id}", s.deleteVolume)
+	mux.HandleFunc("POST /volumes/{id}/actions/detach", s.detachVolume)
+	// resources hmp only cleans up are never created by the fake
+	mux.HandleFunc("GET /firewalls", emptyList("firewalls"))
+	mux.HandleFunc("GET /networks", emptyList("networks"))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("%s %s is not supported by the fake", r.Method, r.URL.Path))
+	})
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client returns a hcloud client talking to the fake API
+func (s *Server) Client() *hcloud.Client {
+	return hcloud.NewClient(
+		hcloud.WithEndpoint(s.URL),
+		hcloud.WithToken("fake"),
+		hcloud.WithPollOpts(hcloud.PollOpts{BackoffFunc: hcloud.ConstantBackoff(10 * time.Millisecond)}),
+		hcloud.WithRetryOpts(hcloud.RetryOpts{MaxRetries: 0}),
+	)
+}
+
+// AddServerType adds a server type and returns its id
+func (s *Server) AddServerType(serverType schema.ServerType) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	serverType.ID = s.id()
+	s.serverTypes = append(s.serverTypes, serverType)
+	return serverType.ID
+}
+
+// AddDatacenter adds a datacenter in the given location, where the named server types are available
+func (s *Server) AddDatacenter(name, location string, serverTypeNames ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	datacenter := schema.Datacenter{
+		ID:       s.id(),
+		Name:     name,
+		Location: schema.Location{ID: s.id(), Name: location, NetworkZone: "eu-central"},
+	}
+	for _, serverType := range s.serverTypes {
+		if slices.Contains(serverTypeNames, serverType.Name) {
+			datacenter.ServerTypes.Supported = append(datacenter.ServerTypes.Supported, serverType.ID)
+			datacenter.ServerTypes.Available = append(datacenter.ServerTypes.Available, serverType.ID)
+		}
+	}
+	s.datacenters = append(s.datacenters, datacenter)
+}
+
+// AddImage adds an available image and returns its id
+func (s *Server) AddImage(image schema.Image) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	image.ID = s.id()
+	image.Status = "available"
+	if image.Created == nil {
+		image.Created = hcloud.Ptr(time.Now())
+	}
+	s.images = append(s.images, image)
+	return image.ID
+}
+
+// AddVolume adds a volume and returns its id
+func (s *Server) AddVolume(volume schema.Volume) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	volume.ID = s.id()
+	volume.Status = "available"
+	volume.LinuxDevice = fmt.Sprintf("/dev/disk/by-id/scsi-0HC_Volume_%d", volume.ID)
+	volume.Created = time.Now()
+	s.volumes = append(s.volumes, volume)
+	return volume.ID
+}
+
+// Servers returns the existing servers
+func (s *Server) Servers() []schema.Server {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.servers)
+}
+
+// PlacementGroups returns the existing placement groups
+func (s *Server) PlacementGroups() []schema.PlacementGroup {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.placementGroups)
+}
+
+// Images returns the existing images
+func (s *Server) Images() []schema.Image {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.images)
+}
+
+// SSHKeys returns the existing ssh keys
+func (s *Server) SSHKeys() []schema.SSHKey {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.sshKeys)
+}
+
+// Volumes returns the existing volumes
+func (s *Server) Volumes() []schema.Volume {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.volumes)
+}
+
+// AuthorizedKeys returns the public keys injected into the named server on creation
+func (s *Server) AuthorizedKeys(serverName string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, server := range s.servers {
+		if server.Name == serverName {
+			return slices.Clone(s.authorizedKeys[server.ID])
+		}
+	}
+	return nil
+}
+
+// UserData returns the user data the named server has last been created with
+func (s *Server) UserData(serverName string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.userData[serverName]
+}
+
+// id returns a new resource id; the mutex must be held
+func (s *Server) id() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// action records a finished action for the given resource; the mutex must be held
+func (s *Server) action(command, resourceType string, resourceID int64) schema.Action {
+	now := time.Now()
+	action := schema.Action{
+		ID:        s.id(),
+		Status:    "success",
+		Command:   command,
+		Progress:  100,
+		Started:   now,
+		Finished:  &now,
+		Resources: []schema.ActionResourceReference{{ID: resourceID, Type: resourceType}},
+	}
+	s.actions[action.ID] = action
+	return action
+}
+
+func (s *Server) listActions(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	actions := []schema.Action{}
+	for _, id := range r.URL.Query()["id"] {
+		actionID, _ := strconv.ParseInt(id, 10, 64)
+		if action, found := s.actions[actionID]; found {
+			actions = append(actions, action)
+		}
+	}
+	writeJSON(w, http.StatusOK, schema.ActionListResponse{Actions: actions})
+}
+
+func (s *Server) getAction(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	action, found := s.actions[pathID(r)]
+	if !found {
+		writeError(w, http.StatusNotFound, "not_found", "action not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, schema.ActionGetResponse{Action: action})
+}
+
+func (s *Server) listDatacenters(w http.ResponseWriter, _ *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, schema.DatacenterListResponse{Datacenters: s.datacenters})
+}
+
+func (s *Server) listServerTypes(w http.ResponseWriter, _ *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, schema.ServerTypeListResponse{ServerTypes: s.serverTypes})
+}
+
+func (s *Server) listImages(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := r.URL.Query()
+	images := []schema.Image{}
+	for _, image := range s.images {
+		// like the api, deprecated images are only listed on request
+		if image.Deprecated != nil && query.Get("include_deprecated") != "true" {
+			continue
+		}
+		if matchesAny(query["type"], image.Type) && matchesAny(query["status"], image.Status) &&
+			matchesAny(query["architecture"], image.Architecture) && matchesAny(query["name"], valueOf(image.Name)) &&
+			MatchLabelSelector(query.Get("label_selector"), image.Labels) {
+			images = append(images, image)
+		}
+	}
+	writeJSON(w, http.StatusOK, schema.ImageListResponse{Images: images})
+}
+
+func (s *Server) getImage(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.images, func(image schema.Image) bool { return image.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "image not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, schema.ImageGetResponse{Image: s.images[index]})
+}
+
+func (s *Server) deleteImage(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.images, func(image schema.Image) bool { return image.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "image not found")
+		return
+	}
+	s.images = slices.Delete(s.images, index, index+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listPlacementGroups(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := r.URL.Query()
+	placementGroups := []schema.PlacementGroup{}
+	for _, placementGroup := range s.placementGroups {
+		if matchesAny(query["name"], placementGroup.Name) && matchesAny(query["type"], placementGroup.Type) &&
+			MatchLabelSelector(query.Get("label_selector"), placementGroup.Labels) {
+			placementGroups = append(placementGroups, placementGroup)
+		}
+	}
+	writeJSON(w, http.StatusOK, schema.PlacementGroupListResponse{PlacementGroups: placementGroups})
+}
+
+func (s *Server) createPlacementGroup(w http.ResponseWriter, r *http.Request) {
+	var request schema.PlacementGroupCreateRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		writeError(w, http.StatusBadRequest, "invalid_input", decodeError.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if slices.ContainsFunc(s.placementGroups, func(placementGroup schema.PlacementGroup) bool { return placementGroup.Name == request.Name }) {
+		writeError(w, http.StatusConflict, "uniqueness_error", "placement group name is already used")
+		return
+	}
+	placementGroup := schema.PlacementGroup{
+		ID:      s.id(),
+		Name:    request.Name,
+		Labels:  valueOf(request.Labels),
+		Created: time.Now(),
+		Servers: []int64{},
+		Type:    request.Type,
+	}
+	s.placementGroups = append(s.placementGroups, placementGroup)
+	writeJSON(w, http.StatusCreated, schema.PlacementGroupCreateResponse{PlacementGroup: placementGroup})
+}
+
+func (s *Server) deletePlacementGroup(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.placementGroups, func(placementGroup schema.PlacementGroup) bool { return placementGroup.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "placement group not found")
+		return
+	}
+	s.placementGroups = slices.Delete(s.placementGroups, index, index+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSSHKeys(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := r.URL.Query()
+	sshKeys := []schema.SSHKey{}
+	for _, sshKey := range s.sshKeys {
+		if matchesAny(query["name"], sshKey.Name) && MatchLabelSelector(query.Get("label_selector"), sshKey.Labels) {
+			sshKeys = append(sshKeys, sshKey)
+		}
+	}
+	writeJSON(w, http.StatusOK, schema.SSHKeyListResponse{SSHKeys: sshKeys})
+}
+
+func (s *Server) createSSHKey(w http.ResponseWriter, r *http.Request) {
+	var request schema.SSHKeyCreateRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		writeError(w, http.StatusBadRequest, "invalid_input", decodeError.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, sshKey := range s.sshKeys {
+		if sshKey.Name == request.Name {
+			writeError(w, http.StatusConflict, "uniqueness_error", "SSH key with the same name already exists")
+			return
+		}
+	}
+	sshKey := schema.SSHKey{
+		ID:        s.id(),
+		Name:      request.Name,
+		PublicKey: request.PublicKey,
+		Labels:    valueOf(request.Labels),
+		Created:   time.Now(),
+	}
+	s.sshKeys = append(s.sshKeys, sshKey)
+	writeJSON(w, http.StatusCreated, schema.SSHKeyCreateResponse{SSHKey: sshKey})
+}
+
+func (s *Server) deleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.sshKeys, func(sshKey schema.SSHKey) bool { return sshKey.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "SSH key not found")
+		return
+	}
+	s.sshKeys = slices.Delete(s.sshKeys, index, index+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listServers(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := r.URL.Query()
+	servers := []schema.Server{}
+	for _, server := range s.servers {
+		if matchesAny(query["name"], server.Name) && matchesAny(query["status"], server.Status) &&
+			MatchLabelSelector(query.Get("label_selector"), server.Labels) {
+			servers = append(servers, server)
+		}
+	}
+	writeJSON(w, http.StatusOK, schema.ServerListResponse{Servers: servers})
+}
+
+func (s *Server) createServer(w http.ResponseWriter, r *http.Request) {
+	var request schema.ServerCreateRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		writeError(w, http.StatusBadRequest, "invalid_input", decodeError.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if slices.ContainsFunc(s.servers, func(server schema.Server) bool { return server.Name == request.Name }) {
+		writeError(w, http.StatusConflict, "uniqueness_error", "server name is already used")
+		return
+	}
+	serverTypeIndex := slices.IndexFunc(s.serverTypes, func(serverType schema.ServerType) bool {
+		return serverType.ID == request.ServerType.ID || serverType.Name == request.ServerType.Name
+	})
+	if serverTypeIndex < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_input", "server type not found")
+		return
+	}
+	imageIndex := slices.IndexFunc(s.images, func(image schema.Image) bool {
+		return image.ID == request.Image.ID || (request.Image.Name != "" && valueOf(image.Name) == request.Image.Name)
+	})
+	if imageIndex < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_input", "image not found")
+		return
+	}
+	datacenterIndex := slices.IndexFunc(s.datacenters, func(datacenter schema.Datacenter) bool {
+		return datacenter.Location.Name == request.Location || (request.Location == "" && datacenter.Name == request.Datacenter)
+	})
+	if datacenterIndex < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_input", "location not found")
+		return
+	}
+	if !slices.Contains(s.datacenters[datacenterIndex].ServerTypes.Available, s.serverTypes[serverTypeIndex].ID) {
+		writeError(w, http.StatusPreconditionFailed, "resource_unavailable", "server type is not available in this location")
+		return
+	}
+
+	placementGroupIndex := -1
+	if request.PlacementGroup != 0 {
+		placementGroupIndex = slices.IndexFunc(s.placementGroups, func(placementGroup schema.PlacementGroup) bool {
+			return placementGroup.ID == request.PlacementGroup
+		})
+		if placementGroupIndex < 0 {
+			writeError(w, http.StatusNotFound, "not_found", "placement group not found")
+			return
+		}
+		if len(s.placementGroups[placementGroupIndex].Servers) >= maxPlacementGroupServers {
+			writeError(w, http.StatusPreconditionFailed, "placement_error", "placement group is full")
+			return
+		}
+	}
+
+	image := s.images[imageIndex]
+	server := schema.Server{
+		ID:         s.id(),
+		Name:       request.Name,
+		Status:     "running",
+		Created:    time.Now(),
+		PublicNet:  schema.ServerPublicNet{IPv4: schema.ServerPublicNetIPv4{ID: s.id(), IP: s.ServerIP}},
+		ServerType: s.serverTypes[serverTypeIndex],
+		Datacenter: s.datacenters[datacenterIndex],
+		Image:      &image,
+		Labels:     valueOf(request.Labels),
+		Volumes:    request.Volumes,
+	}
+	s.userData[server.Name] = request.UserData
+	for _, sshKey := range s.sshKeys {
+		if slices.Contains(request.SSHKeys, sshKey.ID) {
+			s.authorizedKeys[server.ID] = append(s.authorizedKeys[server.ID], sshKey.PublicKey)
+		}
+	}
+	for _, volumeID := range request.Volumes {
+		volumeIndex := slices.IndexFunc(s.volumes, func(volume schema.Volume) bool { return volume.ID == volumeID })
+		if volumeIndex < 0 || s.volumes[volumeIndex].Server != nil {
+			writeError(w, http.StatusPreconditionFailed, "volume_already_attached", "volume not found or already attached")
+			return
+		}
+	}
+	for _, volumeID := range request.Volumes {
+		volumeIndex := slices.IndexFunc(s.volumes, func(volume schema.Volume) bool { return volume.ID == volumeID })
+		s.volumes[volumeIndex].Server = hcloud.Ptr(server.ID)
+	}
+	if placementGroupIndex >= 0 {
+		s.placementGroups[placementGroupIndex].Servers = append(s.placementGroups[placementGroupIndex].Servers, server.ID)
+		placementGroup := s.placementGroups[placementGroupIndex]
+		server.PlacementGroup = &placementGroup
+	}
+	s.servers = append(s.servers, server)
+	writeJSON(w, http.StatusCreated, schema.ServerCreateResponse{
+		Server:       server,
+		Action:       s.action("create_server", "server", server.ID),
+		NextActions:  []schema.Action{s.action("start_server", "server", server.ID)},
+		RootPassword: hcloud.Ptr("fake"),
+	})
+}
+
+func (s *Server) getServer(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.servers, func(server schema.Server) bool { return server.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, schema.ServerGetResponse{Server: s.servers[index]})
+}
+
+func (s *Server) deleteServer(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.servers, func(server schema.Server) bool { return server.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	s.servers = slices.Delete(s.servers, index, index+1)
+	delete(s.authorizedKeys, id)
+	// like the api, attached volumes are detached from deleted servers
+	for i := range s.volumes {
+		if valueOf(s.volumes[i].Server) == id {
+			s.volumes[i].Server = nil
+		}
+	}
+	for i := range s.placementGroups {
+		s.placementGroups[i].Servers = slices.DeleteFunc(s.placementGroups[i].Servers, func(serverID int64) bool { return serverID == id })
+	}
+	writeJSON(w, http.StatusOK, schema.ServerDeleteResponse{Action: s.action("delete_server", "server", id)})
+}
+
+func (s *Server) requestConsole(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	if !slices.ContainsFunc(s.servers, func(server schema.Server) bool { return server.ID == id }) {
+		writeError(w, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	// no console is served, so capturing it fails without leaving the fake
+	writeJSON(w, http.StatusCreated, schema.ServerActionRequestConsoleResponse{
+		Action:   s.action("request_console", "server", id),
+		WSSURL:   fmt.Sprintf("ws%s/console?server_id=%d", strings.TrimPrefix(s.URL, "http"), id),
+		Password: "fake",
+	})
+}
+
+// shutdownServer turns the server off right away, as if the os had shut down immediately
+func (s *Server) shutdownServer(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.servers, func(server schema.Server) bool { return server.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	s.servers[index].Status = "off"
+	writeJSON(w, http.StatusCreated, schema.ServerActionShutdownResponse{Action: s.action("shutdown_server", "server", id)})
+}
+
+func (s *Server) createServerImage(w http.ResponseWriter, r *http.Request) {
+	var request schema.ServerActionCreateImageRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		writeError(w, http.StatusBadRequest, "invalid_input", decodeError.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.servers, func(server schema.Server) bool { return server.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "server not found")
+		return
+	}
+	server := s.servers[index]
+	image := schema.Image{
+		ID:           s.id(),
+		Type:         valueOf(request.Type),
+		Status:       "available",
+		Description:  valueOf(request.Description),
+		Created:      hcloud.Ptr(time.Now()),
+		CreatedFrom:  &schema.ImageCreatedFrom{ID: server.ID, Name: server.Name},
+		OSFlavor:     server.Image.OSFlavor,
+		OSVersion:    server.Image.OSVersion,
+		Architecture: server.Image.Architecture,
+		Labels:       valueOf(request.Labels),
+	}
+	s.images = append(s.images, image)
+	writeJSON(w, http.StatusCreated, schema.ServerActionCreateImageResponse{Action: s.action("create_image", "server", id), Image: image})
+}
+
+func (s *Server) listVolumes(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	query := r.URL.Query()
+	volumes := []schema.Volume{}
+	for _, volume := range s.volumes {
+		if matchesAny(query["name"], volume.Name) && matchesAny(query["status"], volume.Status) &&
+			MatchLabelSelector(query.Get("label_selector"), volume.Labels) {
+			volumes = append(volumes, volume)
+		}
+	}
+	writeJSON(w, http.StatusOK, schema.VolumeListResponse{Volumes: volumes})
+}
+
+func (s *Server) createVolume(w http.ResponseWriter, r *http.Request) {
+	var request schema.VolumeCreateRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		writeError(w, http.StatusBadRequest, "invalid_input", decodeError.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if slices.ContainsFunc(s.volumes, func(volume schema.Volume) bool { return volume.Name == request.Name }) {
+		writeError(w, http.StatusConflict, "uniqueness_error", "volume name is already used")
+		return
+	}
+	location := valueOf(request.Location)
+	datacenterIndex := slices.IndexFunc(s.datacenters, func(datacenter schema.Datacenter) bool {
+		return datacenter.Location.ID == location.ID || datacenter.Location.Name == location.Name
+	})
+	if datacenterIndex < 0 {
+		writeError(w, http.StatusBadRequest, "invalid_input", "location not found")
+		return
+	}
+
+	volume := schema.Volume{
+		ID:       s.id(),
+		Name:     request.Name,
+		Status:   "available",
+		Location: s.datacenters[datacenterIndex].Location,
+		Size:     request.Size,
+		Format:   request.Format,
+		Labels:   valueOf(request.Labels),
+		Created:  time.Now(),
+	}
+	volume.LinuxDevice = fmt.Sprintf("/dev/disk/by-id/scsi-0HC_Volume_%d", volume.ID)
+	s.volumes = append(s.volumes, volume)
+	action := s.action("create_volume", "volume", volume.ID)
+	writeJSON(w, http.StatusCreated, schema.VolumeCreateResponse{Volume: volume, Action: &action, NextActions: []schema.Action{}})
+}
+
+func (s *Server) getVolume(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.volumes, func(volume schema.Volume) bool { return volume.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "volume not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, schema.VolumeGetResponse{Volume: s.volumes[index]})
+}
+
+func (s *Server) updateVolume(w http.ResponseWriter, r *http.Request) {
+	var request schema.VolumeUpdateRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		writeError(w, http.StatusBadRequest, "invalid_input", decodeError.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.volumes, func(volume schema.Volume) bool { return volume.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "volume not found")
+		return
+	}
+	if request.Name != "" {
+		s.volumes[index].Name = request.Name
+	}
+	if request.Labels != nil {
+		s.volumes[index].Labels = *request.Labels
+	}
+	writeJSON(w, http.StatusOK, schema.VolumeUpdateResponse{Volume: s.volumes[index]})
+}
+
+func (s *Server) deleteVolume(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.volumes, func(volume schema.Volume) bool { return volume.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "volume not found")
+		return
+	}
+	if s.volumes[index].Server != nil {
+		writeError(w, http.StatusLocked, "locked", "volume is attached to a server")
+		return
+	}
+	s.volumes = slices.Delete(s.volumes, index, index+1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) detachVolume(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	id := pathID(r)
+	index := slices.IndexFunc(s.volumes, func(volume schema.Volume) bool { return volume.ID == id })
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not_found", "volume not found")
+		return
+	}
+	s.volumes[index].Server = nil
+	writeJSON(w, http.StatusCreated, schema.VolumeActionDetachVolumeResponse{Action: s.action("detach_volume", "volume", id)})
+}
+
+// MatchLabelSelector reports whether the labels match the selector; equality, inequality and (non-)existence
+// expressions separated by ',' are supported
+func MatchLabelSelector(selector string, labels map[string]string) bool {
+	for _, expression := range strings.Split(selector, ",") {
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			continue
+		}
+
+		var matches bool
+		if key, value, found := strings.Cut(expression, "!="); found {
+			matches = labels[strings.TrimSpace(key)] != strings.TrimSpace(value)
+		} else if key, value, found := strings.Cut(expression, "="); found {
+			labelValue, exists := labels[strings.TrimSpace(key)]
+			matches = exists && labelValue == strings.TrimSpace(strings.TrimPrefix(value, "="))
+		} else if key, found := strings.CutPrefix(expression, "!"); found {
+			_, exists := labels[strings.TrimSpace(key)]
+			matches = !exists
+		} else {
+			_, matches = labels[expression]
+		}
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesAny reports whether value is one of the filter values; an empty filter matches everything
+func matchesAny(filter []string, value string) bool {
+	return len(filter) == 0 || slices.Contains(filter, value)
+}
+
+func pathID(r *http.Request) int64 {
+	id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	return id
+}
+
+func emptyList(resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]any{resource: {}})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, schema.ErrorResponse{Error: schema.Error{Code: code, Message: message}})
+}
+
+// valueOf returns the value p points to, or the zero value for nil
+func valueOf[T any](p *T) T {
+	var value T
+	if p != nil {
+		value = *p
+	}
+	return value
+}
@@ -0,0 +1,295 @@
+// Package fakessh implements an in-process ssh server standing in for a job server in tests. Commands are run by
+// sh in a temporary directory, with stub executables for tools only present on real servers, like cloud-init.
+// Slow boots and dropped connections can be simulated.
+package fakessh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultStubs are installed unless overridden; cloud-init reports a finished boot
+var DefaultStubs = map[string]string{
+	"cloud-init": "#!/bin/sh\necho 'status: done'\n",
+}
+
+// Server is an ssh server listening on a random local port
+type Server struct {
+	// Dir is the working directory of all commands; stubs are located in Dir/bin, which is prepended to PATH
+	Dir string
+	// Authorize decides whether a client key is accepted
+	Authorize func(key ssh.PublicKey) bool
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	bootTime time.Time
+	drops    atomic.Int32
+
+	mutex       sync.Mutex
+	commands    []string
+	connections map[net.Conn]struct{}
+	wg          sync.WaitGroup
+}
+
+// Options configure a Server
+type Options struct {
+	// AuthorizedKeys are the accepted client keys; ignored if Authorize is set
+	AuthorizedKeys []ssh.PublicKey
+	// Authorize decides whether a client key is accepted
+	Authorize func(key ssh.PublicKey) bool
+	// BootDelay is the time after start during which connections are closed before the ssh handshake
+	BootDelay time.Duration
+	// Stubs are executables by name, installed in addition to DefaultStubs
+	Stubs map[string]string
+}
+
+// Start starts a server; it has to be closed after use
+func Start(options Options) (*Server, error) {
+	dir, dirError := os.MkdirTemp("", "fakessh-")
+	if dirError != nil {
+		return nil, dirError
+	}
+
+	s := &Server{
+		Dir:         dir,
+		Authorize:   options.Authorize,
+		bootTime:    time.Now().Add(options.BootDelay),
+		connections: map[net.Conn]struct{}{},
+	}
+	if s.Authorize == nil {
+		s.Authorize = func(key ssh.PublicKey) bool {
+			return slices.ContainsFunc(options.AuthorizedKeys, func(authorizedKey ssh.PublicKey) bool {
+				return bytes.Equal(authorizedKey.Marshal(), key.Marshal())
+			})
+		}
+	}
+
+	stubs := map[string]string{}
+	for name, script := range DefaultStubs {
+		stubs[name] = script
+	}
+	for name, script := range options.Stubs {
+		stubs[name] = script
+	}
+	if stubError := s.installStubs(stubs); stubError != nil {
+		os.RemoveAll(dir)
+		return nil, stubError
+	}
+
+	_, hostKey, keyError := ed25519.GenerateKey(rand.Reader)
+	if keyError != nil {
+		os.RemoveAll(dir)
+		return nil, keyError
+	}
+	hostSigner, signerError := ssh.NewSignerFromKey(hostKey)
+	if signerError != nil {
+		os.RemoveAll(dir)
+		return nil, signerError
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.Authorize(key) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key %s", ssh.FingerprintSHA256(key))
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+
+	listener, listenError := net.Listen("tcp", "127.0.0.1:0")
+	if listenError != nil {
+		os.RemoveAll(dir)
+		return nil, listenError
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host returns the address the server listens on
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server listens on
+func (s *Server) Port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// DropSessions drops the connection of the next n sessions as soon as they request to run a command
+func (s *Server) DropSessions(n int) {
+	s.drops.Store(int32(n))
+}
+
+// Commands returns all commands run so far
+func (s *Server) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.commands)
+}
+
+// Close stops the server, closes all connections and removes the working directory
+func (s *Server) Close() error {
+	closeError := s.listener.Close()
+	s.mutex.Lock()
+	for connection := range s.connections {
+		connection.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+	return errors.Join(closeError, os.RemoveAll(s.Dir))
+}
+
+func (s *Server) installStubs(stubs map[string]string) error {
+	binDir := filepath.Join(s.Dir, "bin")
+	if mkdirError := os.Mkdir(binDir, 0o755); mkdirError != nil {
+		return mkdirError
+	}
+	for name, script := range stubs {
+		if writeError := os.WriteFile(filepath.Join(binDir, name), []byte(script), 0o755); writeError != nil {
+			return writeError
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		connection, acceptError := s.listener.Accept()
+		if acceptError != nil {
+			return
+		}
+		// a booting server does not accept ssh connections yet
+		if time.Now().Before(s.bootTime) {
+			connection.Close()
+			continue
+		}
+
+		s.mutex.Lock()
+		s.connections[connection] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConnection(connection)
+			s.mutex.Lock()
+			delete(s.connections, connection)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+func (s *Server) handleConnection(connection net.Conn) {
+	defer connection.Close()
+	serverConnection, channels, requests, handshakeError := ssh.NewServerConn(connection, s.config)
+	if handshakeError != nil {
+		return
+	}
+	defer serverConnection.Close()
+	go ssh.DiscardRequests(requests)
+
+	var sessions sync.WaitGroup
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, acceptError := newChannel.Accept()
+		if acceptError != nil {
+			continue
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			s.handleSession(serverConnection, channel, channelRequests)
+		}()
+	}
+	sessions.Wait()
+}
+
+func (s *Server) handleSession(connection *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	var command *exec.Cmd
+	exited := make(chan struct{})
+	for request := range requests {
+		switch request.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if command != nil || ssh.Unmarshal(request.Payload, &payload) != nil {
+				request.Reply(false, nil)
+				continue
+			}
+			s.mutex.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mutex.Unlock()
+
+			if s.drops.Add(-1) >= 0 {
+				connection.Close()
+				return
+			}
+
+			command = exec.Command("sh", "-c", payload.Command)
+			command.Dir = s.Dir
+			command.Env = []string{
+				"PATH=" + filepath.Join(s.Dir, "bin") + string(os.PathListSeparator) + os.Getenv("PATH"),
+				"HOME=" + s.Dir,
+			}
+			command.Stdout = channel
+			command.Stderr = channel.Stderr()
+			// children of a terminated shell might keep the output open
+			command.WaitDelay = time.Second
+			if startError := command.Start(); startError != nil {
+				request.Reply(false, nil)
+				return
+			}
+			request.Reply(true, nil)
+
+			go func() {
+				defer close(exited)
+				exitStatus := uint32(0)
+				var exitError *exec.ExitError
+				if waitError := command.Wait(); errors.As(waitError, &exitError) && exitError.ExitCode() >= 0 {
+					exitStatus = uint32(exitError.ExitCode())
+				} else if waitError != nil {
+					// terminated by a signal
+					exitStatus = 255
+				}
+				channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, exitStatus))
+				channel.Close()
+			}()
+		case "signal":
+			if command != nil && command.Process != nil {
+				command.Process.Signal(syscall.SIGTERM)
+			}
+			request.Reply(true, nil)
+		default:
+			request.Reply(false, nil)
+		}
+	}
+
+	// the client has closed the session, so the command is not needed anymore
+	if command != nil {
+		command.Process.Kill()
+		<-exited
+	}
+}
@@ -0,0 +1,70 @@
+package helper
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const cloudConfigHeader = "#cloud-config"
+
+// CloudInitExtra contains the cloud-config keys which can be extended by jobs
+type CloudInitExtra struct {
+	Packages   []any `yaml:"packages"`
+	Runcmd     []any `yaml:"runcmd"`
+	WriteFiles []any `yaml:"write_files"`
+}
+
+// MergeCloudInit merges the extra packages, runcmd and write_files into the cloud-config user data.
+// Extra commands are run before the ones of the user data, so the server is not signaled ready before they are done.
+// The result is validated to be a yaml mapping in any case.
+func MergeCloudInit(userData, extra string) (string, error) {
+	if !strings.HasPrefix(userData, cloudConfigHeader) {
+		return "", fmt.Errorf("cloud-init user data must start with %+q", cloudConfigHeader)
+	}
+
+	var config map[string]any
+	if err := yaml.Unmarshal([]byte(userData), &config); err != nil {
+		return "", fmt.Errorf("cloud-init user data is invalid: %w", err)
+	}
+	if config == nil {
+		config = map[string]any{}
+	}
+
+	if strings.TrimSpace(extra) == "" {
+		return userData, nil
+	}
+
+	var extraConfig CloudInitExtra
+	decoder := yaml.NewDecoder(strings.NewReader(extra))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&extraConfig); err != nil {
+		return "", fmt.Errorf("extra cloud-init config is invalid (only packages, runcmd and write_files are supported): %w", err)
+	}
+
+	for key, values := range map[string][]any{
+		"packages":    extraConfig.Packages,
+		"write_files": extraConfig.WriteFiles,
+	} {
+		if len(values) > 0 {
+			existing, _ := config[key].([]any)
+			config[key] = append(existing, values...)
+		}
+	}
+	if len(extraConfig.Runcmd) > 0 {
+		existing, _ := config["runcmd"].([]any)
+		config["runcmd"] = append(extraConfig.Runcmd, existing...)
+	}
+
+	merged := &bytes.Buffer{}
+	merged.WriteString(cloudConfigHeader + "\n")
+	encoder := yaml.NewEncoder(merged)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		return "", err
+	}
+
+	return merged.String(), nil
+}
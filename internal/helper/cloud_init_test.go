@@ -0,0 +1,88 @@
+package helper_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+const baseUserData = `#cloud-config
+packages:
+  - git
+runcmd:
+  - systemctl restart ssh
+`
+
+func TestMergeCloudInit(t *testing.T) {
+	for _, testCase := range []struct {
+		name       string
+		userData   string
+		extra      string
+		expected   map[string]any
+		shouldFail bool
+	}{
+		{
+			name:     "without extra",
+			userData: baseUserData,
+			expected: map[string]any{
+				"packages": []any{"git"},
+				"runcmd":   []any{"systemctl restart ssh"},
+			},
+		},
+		{
+			name:     "merge extra",
+			userData: baseUserData,
+			extra: `packages: [golang]
+runcmd:
+  - [sh, -c, "echo extra"]
+write_files:
+  - path: /etc/motd
+    content: hello
+`,
+			expected: map[string]any{
+				"packages":    []any{"git", "golang"},
+				"runcmd":      []any{[]any{"sh", "-c", "echo extra"}, "systemctl restart ssh"},
+				"write_files": []any{map[string]any{"path": "/etc/motd", "content": "hello"}},
+			},
+		},
+		{
+			name:       "unsupported extra key",
+			userData:   baseUserData,
+			extra:      "users: [root]",
+			shouldFail: true,
+		},
+		{
+			name:       "invalid extra yaml",
+			userData:   baseUserData,
+			extra:      "packages: [golang",
+			shouldFail: true,
+		},
+		{
+			name:       "invalid user data",
+			userData:   "#cloud-config\npackages: [git\n",
+			shouldFail: true,
+		},
+		{
+			name:       "missing cloud-config header",
+			userData:   "packages: [git]\n",
+			shouldFail: true,
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			merged, err := helper.MergeCloudInit(testCase.userData, testCase.extra)
+			if testCase.shouldFail {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Regexp(t, "^#cloud-config\n", merged)
+
+			var config map[string]any
+			assert.NoError(t, yaml.Unmarshal([]byte(merged), &config))
+			assert.Equal(t, testCase.expected, config)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses a duration like time.ParseDuration, additionally accepting whole days like "30d"
+func ParseDuration(duration string) (time.Duration, error) {
+	if days, isDays := strings.CutSuffix(duration, "d"); isDays {
+		dayCount, parseError := strconv.ParseUint(days, 10, 16)
+		if parseError != nil {
+			return 0, fmt.Errorf("invalid duration %+q", duration)
+		}
+		return time.Duration(dayCount) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(duration)
+}
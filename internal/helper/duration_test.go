@@ -0,0 +1,30 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		input         string
+		expected      time.Duration
+		expectedError bool
+	}{
+		{"days", "30d", 30 * 24 * time.Hour, false},
+		{"hours", "36h", 36 * time.Hour, false},
+		{"zero", "0", 0, false},
+		{"fractional days", "1.5d", 0, true},
+		{"negative days", "-1d", 0, true},
+		{"invalid", "soon", 0, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			duration, err := ParseDuration(testCase.input)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			assert.Equal(t, testCase.expected, duration)
+		})
+	}
+}
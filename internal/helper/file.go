@@ -0,0 +1,34 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it afterwards,
+// so readers either see the old or the new content but never a partially written file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tempFile, createError := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if createError != nil {
+		return createError
+	}
+	// no-op after a successful rename
+	defer os.Remove(tempFile.Name())
+
+	if _, writeError := tempFile.Write(data); writeError != nil {
+		tempFile.Close()
+		return writeError
+	}
+	if syncError := tempFile.Sync(); syncError != nil {
+		tempFile.Close()
+		return syncError
+	}
+	if closeError := tempFile.Close(); closeError != nil {
+		return closeError
+	}
+	if chmodError := os.Chmod(tempFile.Name(), perm); chmodError != nil {
+		return chmodError
+	}
+
+	return os.Rename(tempFile.Name(), path)
+}
@@ -0,0 +1,138 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	cacheFileSuffix = ".json"
+	cacheLockSuffix = ".lock"
+	// cacheLockTimeout bounds the wait for a concurrent invocation filling the same cache entry
+	cacheLockTimeout = 30 * time.Second
+)
+
+// FileCache is an on-disk cache of json encoded values, shared between concurrent processes
+type FileCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+type cacheEntry struct {
+	Created time.Time       `json:"created"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// DefaultCacheDir returns the cache directory according to the XDG base directory specification
+func DefaultCacheDir() string {
+	if cacheHome := os.Getenv("XDG_CACHE_HOME"); cacheHome != "" {
+		return filepath.Join(cacheHome, "hmp")
+	}
+	if home, homeError := os.UserHomeDir(); homeError == nil {
+		return filepath.Join(home, ".cache", "hmp")
+	}
+	return "hmp-cache"
+}
+
+// Cached returns the value cached under key, or fetches and caches it if the entry is missing or expired.
+// Concurrent callers wait for the first one to fill the entry instead of fetching it themselves.
+// Without a cache, the value is always fetched.
+func Cached[T any](cache *FileCache, key string, fetch func() (T, error)) (T, error) {
+	if cache == nil || cache.TTL <= 0 {
+		return fetch()
+	}
+
+	var value T
+	if mkdirError := os.MkdirAll(cache.Dir, 0o700); mkdirError != nil {
+		return value, mkdirError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheLockTimeout)
+	defer cancel()
+	lock, lockError := AcquireFileLock(ctx, filepath.Join(cache.Dir, key+cacheLockSuffix))
+	if lockError != nil {
+		return value, fmt.Errorf("cannot lock cache entry %s: %w", key, lockError)
+	}
+	defer lock.Release()
+
+	if found, readError := cache.read(key, &value); readError == nil && found {
+		return value, nil
+	}
+
+	value, fetchError := fetch()
+	if fetchError != nil {
+		return value, fetchError
+	}
+	// a cache which cannot be written only costs additional requests next time
+	if writeError := cache.write(key, value); writeError != nil {
+		fmt.Printf("\t\t⚠️ Cannot write cache entry %s: %v\n", key, writeError)
+	}
+	return value, nil
+}
+
+// WithMaxTTL returns a view of the cache whose entries expire after ttl at the latest
+func (c *FileCache) WithMaxTTL(ttl time.Duration) *FileCache {
+	if c == nil {
+		return nil
+	}
+	return &FileCache{Dir: c.Dir, TTL: min(c.TTL, ttl)}
+}
+
+// Clear removes all cache entries
+func (c *FileCache) Clear() error {
+	entries, globError := filepath.Glob(filepath.Join(c.Dir, "*"+cacheFileSuffix))
+	if globError != nil {
+		return globError
+	}
+	var removeErrors []error
+	for _, entry := range entries {
+		if removeError := os.Remove(entry); removeError != nil && !errors.Is(removeError, os.ErrNotExist) {
+			removeErrors = append(removeErrors, removeError)
+		}
+	}
+	return errors.Join(removeErrors...)
+}
+
+func (c *FileCache) path(key string) string {
+	return filepath.Join(c.Dir, key+cacheFileSuffix)
+}
+
+// read decodes the entry into value; expired or unreadable entries are reported as not found
+func (c *FileCache) read(key string, value any) (bool, error) {
+	data, readError := os.ReadFile(c.path(key))
+	if readError != nil {
+		if errors.Is(readError, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, readError
+	}
+
+	var entry cacheEntry
+	if decodeError := json.Unmarshal(data, &entry); decodeError != nil {
+		return false, nil
+	}
+	if time.Since(entry.Created) > c.TTL {
+		return false, nil
+	}
+	if decodeError := json.Unmarshal(entry.Data, value); decodeError != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (c *FileCache) write(key string, value any) error {
+	data, encodeError := json.Marshal(value)
+	if encodeError != nil {
+		return encodeError
+	}
+	entryData, encodeError := json.Marshal(cacheEntry{Created: time.Now(), Data: data})
+	if encodeError != nil {
+		return encodeError
+	}
+	return WriteFileAtomic(c.path(key), entryData, 0o600)
+}
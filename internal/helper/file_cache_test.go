@@ -0,0 +1,91 @@
+package helper_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestCached(t *testing.T) {
+	for _, testCase := range []struct {
+		name            string
+		cache           *helper.FileCache
+		expectedFetches int32
+	}{
+		{"cached", &helper.FileCache{Dir: t.TempDir(), TTL: time.Hour}, 1},
+		{"expired", &helper.FileCache{Dir: t.TempDir(), TTL: time.Nanosecond}, 3},
+		{"disabled", &helper.FileCache{Dir: t.TempDir()}, 3},
+		{"no cache", nil, 3},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			fetch := func() ([]string, error) {
+				fetches.Add(1)
+				return []string{"cx22", "cax11"}, nil
+			}
+
+			for range 3 {
+				value, err := helper.Cached(testCase.cache, "server-types", fetch)
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"cx22", "cax11"}, value)
+			}
+			assert.Equal(t, testCase.expectedFetches, fetches.Load())
+		})
+	}
+}
+
+func TestCachedConcurrent(t *testing.T) {
+	cache := &helper.FileCache{Dir: t.TempDir(), TTL: time.Hour}
+
+	var fetches atomic.Int32
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := helper.Cached(cache, "datacenters", func() (int, error) {
+				fetches.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				return 42, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, 42, value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestCachedFetchError(t *testing.T) {
+	cache := &helper.FileCache{Dir: t.TempDir(), TTL: time.Hour}
+	fetchError := errors.New("api unavailable")
+
+	_, err := helper.Cached(cache, "images", func() (string, error) { return "", fetchError })
+	assert.ErrorIs(t, err, fetchError)
+
+	// errors are not cached
+	value, err := helper.Cached(cache, "images", func() (string, error) { return "ubuntu-24.04", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "ubuntu-24.04", value)
+}
+
+func TestFileCacheClear(t *testing.T) {
+	cache := &helper.FileCache{Dir: t.TempDir(), TTL: time.Hour}
+	_, err := helper.Cached(cache, "images", func() (string, error) { return "ubuntu-22.04", nil })
+	assert.NoError(t, err)
+
+	assert.NoError(t, cache.Clear())
+
+	value, err := helper.Cached(cache, "images", func() (string, error) { return "ubuntu-24.04", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "ubuntu-24.04", value)
+
+	assert.NoError(t, (&helper.FileCache{Dir: t.TempDir() + "/missing"}).Clear())
+}
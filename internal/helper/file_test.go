@@ -0,0 +1,33 @@
+package helper_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "state.json")
+
+	// existing longer content must be replaced completely
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", 4096)), 0644))
+	assert.NoError(t, helper.WriteFileAtomic(path, []byte("content"), 0600))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files must not be left behind")
+}
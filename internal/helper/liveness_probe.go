@@ -6,10 +6,11 @@ import (
 	"time"
 
 	"github.com/avast/retry-go/v4"
+	"golang.org/x/crypto/ssh"
 )
 
-func CheckLivenessSSH(privateKey, serverAddress string) error {
-	sshClient, sshClientError := NewSSHClient(privateKey, serverAddress, CustomSSHPort)
+func CheckLivenessSSH(signer ssh.Signer, serverAddress string, port uint16) error {
+	sshClient, sshClientError := NewSSHClient(signer, serverAddress, port)
 	if sshClientError != nil {
 		return sshClientError
 	}
@@ -17,17 +18,17 @@ func CheckLivenessSSH(privateKey, serverAddress string) error {
 	return sshClient.RunCommand(context.Background(), "true")
 }
 
-func WaitReachable(ctx context.Context, privateKey, serverAddress string) error {
+func WaitReachable(ctx context.Context, signer ssh.Signer, serverAddress string, port uint16, retryDelay time.Duration) error {
 	deadline, _ := ctx.Deadline()
 	return retry.Do(
 		func() error {
-			return CheckLivenessSSH(privateKey, serverAddress)
+			return CheckLivenessSSH(signer, serverAddress, port)
 		},
 		retry.OnRetry(func(n uint, err error) {
 			fmt.Printf("\t\tServer not ready yet: %+q ... retrying (%s remaining)\n", err.Error(), time.Until(deadline).Round(time.Second))
 		}),
 		retry.Attempts(0),
-		retry.Delay(5*time.Second),
+		retry.Delay(retryDelay),
 		retry.DelayType(retry.FixedDelay),
 		retry.LastErrorOnly(true),
 		retry.Context(ctx),
@@ -0,0 +1,51 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	lockRetryInterval = 50 * time.Millisecond
+	// staleLockAge is the age after which a lock file is considered left behind by a crashed process
+	staleLockAge = 10 * time.Minute
+)
+
+// FileLock is an advisory lock based on the exclusive creation of a lock file; it works the same on all platforms
+type FileLock struct {
+	path string
+}
+
+// AcquireFileLock waits until the lock file at path can be created or the context is done
+func AcquireFileLock(ctx context.Context, path string) (*FileLock, error) {
+	for {
+		fh, createError := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if createError == nil {
+			fh.WriteString(strconv.Itoa(os.Getpid()))
+			fh.Close()
+			return &FileLock{path: path}, nil
+		}
+		if !os.IsExist(createError) {
+			return nil, createError
+		}
+
+		if info, statError := os.Stat(path); statError == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cannot acquire lock %s: %w", path, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Release removes the lock file
+func (l *FileLock) Release() error {
+	return os.Remove(l.path)
+}
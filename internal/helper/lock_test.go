@@ -0,0 +1,30 @@
+package helper_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestAcquireFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	lock, err := helper.AcquireFileLock(context.Background(), path)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = helper.AcquireFileLock(ctx, path)
+	assert.Error(t, err, "lock must not be acquired twice")
+
+	assert.NoError(t, lock.Release())
+
+	lock, err = helper.AcquireFileLock(context.Background(), path)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Release())
+}
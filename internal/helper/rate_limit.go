@@ -0,0 +1,197 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitMinRemaining = 100
+	rateLimitMaxRetries   = 5
+	rateLimitBaseDelay    = time.Second
+	rateLimitMaxDelay     = time.Minute
+)
+
+// RateLimitTransport is a http.RoundTripper aware of the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// response headers of the hcloud API. All requests sent through the same transport share the rate limit state:
+// requests are delayed while the remaining budget is low, and 429 and 5xx responses are retried with jittered backoff.
+// Server errors of non-idempotent requests are only retried on 503, as the request may have been processed otherwise.
+// The built-in retries of the hcloud client have to be disabled when using this transport, as both would retry the
+// same responses otherwise.
+type RateLimitTransport struct {
+	// Base is the underlying transport; defaults to http.DefaultTransport
+	Base http.RoundTripper
+	// MinRemaining is the remaining budget below which requests are delayed
+	MinRemaining int
+	// MaxRetries is the number of retries of 429 and 5xx responses
+	MaxRetries int
+
+	mutex     sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	known     bool
+
+	now   func() time.Time
+	sleep func(ctx context.Context, duration time.Duration) error
+}
+
+// NewRateLimitTransport returns a RateLimitTransport with the default budget and retry settings
+func NewRateLimitTransport(base http.RoundTripper) *RateLimitTransport {
+	return &RateLimitTransport{
+		Base:         base,
+		MinRemaining: rateLimitMinRemaining,
+		MaxRetries:   rateLimitMaxRetries,
+	}
+}
+
+func (t *RateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	for retry := 0; ; retry++ {
+		if throttleDelay := t.throttleDelay(); throttleDelay > 0 {
+			fmt.Printf("\t\t⏳ hcloud API budget is low, delaying request by %s\n", throttleDelay.Round(time.Millisecond))
+			if sleepError := t.wait(request.Context(), throttleDelay); sleepError != nil {
+				return nil, sleepError
+			}
+		}
+
+		attemptRequest := request
+		if retry > 0 {
+			var cloneError error
+			if attemptRequest, cloneError = cloneRequest(request); cloneError != nil {
+				return nil, cloneError
+			}
+		}
+
+		response, roundTripError := base.RoundTrip(attemptRequest)
+		if roundTripError != nil {
+			return nil, roundTripError
+		}
+		t.update(response.Header)
+
+		if !retryable(request.Method, response.StatusCode) || retry >= t.MaxRetries {
+			return response, nil
+		}
+
+		retryDelay := t.retryDelay(response, retry)
+		fmt.Printf("\t\t⏳ hcloud API responded %d, retrying in %s (%d/%d)\n", response.StatusCode, retryDelay.Round(time.Millisecond), retry+1, t.MaxRetries)
+		response.Body.Close()
+		if sleepError := t.wait(request.Context(), retryDelay); sleepError != nil {
+			return nil, sleepError
+		}
+	}
+}
+
+// update stores the rate limit state reported by the API
+func (t *RateLimitTransport) update(header http.Header) {
+	remaining, remainingError := strconv.Atoi(header.Get("RateLimit-Remaining"))
+	reset, resetError := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+	if remainingError != nil || resetError != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(header.Get("RateLimit-Limit"))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.limit = limit
+	t.remaining = remaining
+	t.reset = time.Unix(reset, 0)
+	t.known = true
+}
+
+// throttleDelay returns how long to wait before the next request; the budget refills linearly until the reset time,
+// so the delay is the time needed to refill a single request
+func (t *RateLimitTransport) throttleDelay() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.known || t.remaining >= t.MinRemaining {
+		return 0
+	}
+	untilReset := t.reset.Sub(t.currentTime())
+	if untilReset <= 0 {
+		return 0
+	}
+
+	delay := untilReset
+	if missing := t.limit - t.remaining; missing > 0 {
+		delay = untilReset / time.Duration(missing)
+	}
+	// the budget is consumed by this request; concurrent requests of this transport queue up behind it
+	t.remaining--
+	return min(delay, rateLimitMaxDelay)
+}
+
+// retryDelay returns the jittered exponential backoff for the given retry, but waits at least until the reset time
+// if the budget is exhausted
+func (t *RateLimitTransport) retryDelay(response *http.Response, retry int) time.Duration {
+	backoff := rateLimitBaseDelay << retry
+	delay := backoff/2 + rand.N(backoff/2+1)
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		t.mutex.Lock()
+		if t.known && t.remaining <= 0 {
+			delay = max(delay, t.reset.Sub(t.currentTime()))
+		}
+		t.mutex.Unlock()
+	}
+	return min(delay, rateLimitMaxDelay)
+}
+
+func (t *RateLimitTransport) currentTime() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *RateLimitTransport) wait(ctx context.Context, duration time.Duration) error {
+	if t.sleep != nil {
+		return t.sleep(ctx, duration)
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryable reports whether a response to a request with the given method may be retried
+func retryable(method string, statusCode int) bool {
+	switch {
+	case statusCode == http.StatusTooManyRequests, statusCode == http.StatusServiceUnavailable:
+		return true
+	case statusCode >= http.StatusInternalServerError:
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodPut || method == http.MethodDelete
+	}
+	return false
+}
+
+// cloneRequest clones the request including a fresh copy of its body
+func cloneRequest(request *http.Request) (*http.Request, error) {
+	clone := request.Clone(request.Context())
+	if request.Body == nil || request.Body == http.NoBody {
+		return clone, nil
+	}
+	if request.GetBody == nil {
+		return nil, fmt.Errorf("cannot retry %s %s: request body is not replayable", request.Method, request.URL)
+	}
+	body, bodyError := request.GetBody()
+	if bodyError != nil {
+		return nil, bodyError
+	}
+	clone.Body = body
+	return clone, nil
+}
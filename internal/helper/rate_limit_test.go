@@ -0,0 +1,103 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitTransportRetries(t *testing.T) {
+	for _, testCase := range []struct {
+		name             string
+		method           string
+		statusCodes      []int
+		expectedStatus   int
+		expectedRequests int
+	}{
+		{"success", http.MethodGet, []int{200}, 200, 1},
+		{"too many requests", http.MethodPost, []int{429, 429, 201}, 201, 3},
+		{"server error of idempotent request", http.MethodGet, []int{500, 502, 200}, 200, 3},
+		{"server error of non-idempotent request", http.MethodPost, []int{500, 201}, 500, 1},
+		{"service unavailable of non-idempotent request", http.MethodPost, []int{503, 201}, 201, 2},
+		{"client error", http.MethodGet, []int{404, 200}, 404, 1},
+		{"retries exhausted", http.MethodGet, []int{503, 503, 503, 200}, 503, 3},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var requests int
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(testCase.statusCodes[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			var delays []time.Duration
+			transport := NewRateLimitTransport(nil)
+			transport.MaxRetries = 2
+			transport.sleep = func(_ context.Context, duration time.Duration) error {
+				delays = append(delays, duration)
+				return nil
+			}
+
+			request, _ := http.NewRequest(testCase.method, server.URL, strings.NewReader("payload"))
+			response, err := (&http.Client{Transport: transport}).Do(request)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expectedStatus, response.StatusCode)
+			assert.Equal(t, testCase.expectedRequests, requests)
+			assert.Len(t, delays, testCase.expectedRequests-1)
+			for _, body := range bodies {
+				assert.Equal(t, "payload", body)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransportThrottle(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	for _, testCase := range []struct {
+		name          string
+		remaining     int
+		reset         time.Time
+		expectedDelay time.Duration
+	}{
+		{"budget left", 3000, now.Add(600 * time.Second), 0},
+		{"budget low", 50, now.Add(3550 * time.Second), time.Second},
+		{"budget exhausted", 0, now.Add(3600 * time.Second), time.Second},
+		{"reset passed", 10, now.Add(-time.Second), 0},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			transport := NewRateLimitTransport(nil)
+			transport.now = func() time.Time { return now }
+			transport.update(http.Header{
+				"Ratelimit-Limit":     {"3600"},
+				"Ratelimit-Remaining": {strconv.Itoa(testCase.remaining)},
+				"Ratelimit-Reset":     {strconv.FormatInt(testCase.reset.Unix(), 10)},
+			})
+
+			assert.Equal(t, testCase.expectedDelay, transport.throttleDelay())
+		})
+	}
+}
+
+func TestRateLimitTransportWaitsForReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	transport := NewRateLimitTransport(nil)
+	transport.now = func() time.Time { return now }
+	transport.update(http.Header{
+		"Ratelimit-Limit":     {"3600"},
+		"Ratelimit-Remaining": {"0"},
+		"Ratelimit-Reset":     {strconv.FormatInt(now.Add(30*time.Second).Unix(), 10)},
+	})
+
+	assert.Equal(t, 30*time.Second, transport.retryDelay(&http.Response{StatusCode: http.StatusTooManyRequests}, 0))
+	assert.LessOrEqual(t, transport.retryDelay(&http.Response{StatusCode: http.StatusBadGateway}, 0), time.Second)
+}
@@ -0,0 +1,117 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"golang.org/x/crypto/ssh"
+)
+
+const cloudInitOutputLog = "/var/log/cloud-init-output.log"
+
+// ReadinessProbe checks one aspect of the server readiness using an established ssh connection.
+// Errors wrapped with retry.Unrecoverable stop waiting immediately.
+type ReadinessProbe interface {
+	Name() string
+	Probe(ctx context.Context, client *SSHClient) error
+}
+
+// CloudInitProbe waits for cloud-init to finish; a failed cloud-init run is unrecoverable
+type CloudInitProbe struct{}
+
+func (p CloudInitProbe) Name() string {
+	return "cloud-init"
+}
+
+func (p CloudInitProbe) Probe(ctx context.Context, client *SSHClient) error {
+	output, err := client.RunCommandOutput(ctx, "cloud-init status --wait --long")
+	var exitError *ssh.ExitError
+	if errors.As(err, &exitError) {
+		switch exitError.ExitStatus() {
+		case 2:
+			// cloud-init finished with recoverable errors, e.g. deprecated configuration keys
+			fmt.Printf("\t\t⚠️ cloud-init finished with warnings:\n%s", IndentLines(string(output)))
+			return nil
+		default:
+			return retry.Unrecoverable(fmt.Errorf("cloud-init failed with exit status %d:\n%s", exitError.ExitStatus(), IndentLines(string(output))))
+		}
+	}
+	return err
+}
+
+// CommandProbe succeeds once the command exits with status 0
+type CommandProbe struct {
+	Command string
+}
+
+func (p CommandProbe) Name() string {
+	return fmt.Sprintf("command %+q", p.Command)
+}
+
+func (p CommandProbe) Probe(ctx context.Context, client *SSHClient) error {
+	output, err := client.RunCommandOutput(ctx, p.Command)
+	if err != nil && len(output) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return err
+}
+
+// WaitReady waits until the server is reachable via ssh and all probes succeed in order, retrying after retryDelay.
+// On failure, the tail of the cloud-init output log is printed if the server is reachable.
+func WaitReady(ctx context.Context, signer ssh.Signer, serverAddress string, port uint16, retryDelay time.Duration, probes ...ReadinessProbe) error {
+	deadline, _ := ctx.Deadline()
+	var logTail string
+
+	waitError := retry.Do(
+		func() error {
+			sshClient, sshClientError := NewSSHClient(signer, serverAddress, port)
+			if sshClientError != nil {
+				return sshClientError
+			}
+			defer sshClient.Close()
+
+			for _, probe := range probes {
+				if probeError := probe.Probe(ctx, sshClient); probeError != nil {
+					logTail = tailCloudInitOutput(ctx, sshClient)
+					return fmt.Errorf("%s: %w", probe.Name(), probeError)
+				}
+			}
+			return nil
+		},
+		retry.OnRetry(func(n uint, err error) {
+			fmt.Printf("\t\tServer not ready yet: %+q ... retrying (%s remaining)\n", err.Error(), time.Until(deadline).Round(time.Second))
+		}),
+		retry.Attempts(0),
+		retry.Delay(retryDelay),
+		retry.DelayType(retry.FixedDelay),
+		retry.LastErrorOnly(true),
+		retry.Context(ctx),
+	)
+
+	if waitError != nil && logTail != "" {
+		fmt.Printf("📜 Tail of %s:\n%s", cloudInitOutputLog, IndentLines(logTail))
+	}
+	return waitError
+}
+
+// tailCloudInitOutput fetches the last lines of the cloud-init output log; errors are ignored
+func tailCloudInitOutput(ctx context.Context, client *SSHClient) string {
+	tailContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	output, _ := client.RunCommandOutput(tailContext, "tail -n 50 "+cloudInitOutputLog)
+	return string(output)
+}
+
+// IndentLines prefixes every line for the job log
+func IndentLines(text string) string {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return ""
+	}
+	return "\t\t" + strings.ReplaceAll(text, "\n", "\n\t\t") + "\n"
+}
@@ -0,0 +1,40 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Rollback tracks created resources to undo their creation in reverse order
+type Rollback struct {
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	name string
+	undo func(ctx context.Context) error
+}
+
+// Add registers the undo function of a created resource; a nil Rollback ignores it, for callers not rolling back
+func (r *Rollback) Add(name string, undo func(ctx context.Context) error) {
+	if r == nil {
+		return
+	}
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+// Run undoes all registered steps in reverse order; failing steps do not stop the rollback
+func (r *Rollback) Run(ctx context.Context) error {
+	var rollbackErrors []error
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		fmt.Printf("\t\tRoll back %s\n", step.name)
+		if err := step.undo(ctx); err != nil {
+			rollbackErrors = append(rollbackErrors, fmt.Errorf("%s: %w", step.name, err))
+		}
+	}
+	r.steps = nil
+
+	return errors.Join(rollbackErrors...)
+}
@@ -0,0 +1,32 @@
+package helper_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestRollback(t *testing.T) {
+	var order []string
+	rollback := &helper.Rollback{}
+	for _, name := range []string{"first", "second", "third"} {
+		rollback.Add(name, func(ctx context.Context) error {
+			order = append(order, name)
+			if name == "second" {
+				return errors.New("failed")
+			}
+			return nil
+		})
+	}
+
+	err := rollback.Run(context.Background())
+	assert.ErrorContains(t, err, "second: failed")
+	assert.Equal(t, []string{"third", "second", "first"}, order, "all steps must run in reverse order")
+
+	assert.NoError(t, rollback.Run(context.Background()), "steps must only run once")
+	assert.Len(t, order, 3)
+}
@@ -0,0 +1,255 @@
+package helper
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Service       = "s3"
+	s3SigningScheme = "AWS4-HMAC-SHA256"
+	s3DateFormat    = "20060102T150405Z"
+)
+
+// S3Client is a minimal client for S3-compatible object storages like Hetzner Object Storage or MinIO
+type S3Client struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// VirtualHostedStyle addresses the bucket as subdomain of the endpoint instead of the first path element
+	VirtualHostedStyle bool
+
+	HTTPClient *http.Client
+}
+
+type s3ListBucketResult struct {
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (c *S3Client) GetObject(key string) ([]byte, error) {
+	response, requestError := c.do(http.MethodGet, key, nil, nil)
+	if requestError != nil {
+		return nil, requestError
+	}
+	defer response.Body.Close()
+
+	return io.ReadAll(response.Body)
+}
+
+func (c *S3Client) PutObject(key string, data []byte) error {
+	response, requestError := c.do(http.MethodPut, key, nil, data)
+	if requestError != nil {
+		return requestError
+	}
+	return response.Body.Close()
+}
+
+// DeleteObject removes an object; S3 does not report missing objects on deletion
+func (c *S3Client) DeleteObject(key string) error {
+	response, requestError := c.do(http.MethodDelete, key, nil, nil)
+	if requestError != nil {
+		return requestError
+	}
+	return response.Body.Close()
+}
+
+// ListPrefixes returns the common prefixes of all objects below prefix, grouped by '/'
+func (c *S3Client) ListPrefixes(prefix string) ([]string, error) {
+	var prefixes []string
+	continuationToken := ""
+
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"delimiter": {"/"},
+			"prefix":    {prefix},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		response, requestError := c.do(http.MethodGet, "", query, nil)
+		if requestError != nil {
+			return nil, requestError
+		}
+
+		var result s3ListBucketResult
+		decodeError := xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if decodeError != nil {
+			return nil, fmt.Errorf("cannot decode object list: %w", decodeError)
+		}
+
+		for _, commonPrefix := range result.CommonPrefixes {
+			prefixes = append(prefixes, commonPrefix.Prefix)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return prefixes, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// do performs a signed request; missing objects are reported as os.ErrNotExist
+func (c *S3Client) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	requestURL, urlError := c.objectURL(key, query)
+	if urlError != nil {
+		return nil, urlError
+	}
+
+	request, requestError := http.NewRequest(method, requestURL.String(), bytes.NewReader(body))
+	if requestError != nil {
+		return nil, requestError
+	}
+	signS3Request(request, body, c.Region, c.AccessKey, c.SecretKey, time.Now())
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, doError := httpClient.Do(request)
+	if doError != nil {
+		return nil, doError
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, fmt.Errorf("s3 object %+q: %w", key, os.ErrNotExist)
+	}
+	if response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		response.Body.Close()
+		return nil, fmt.Errorf("s3 %s %+q failed with status %d: %s", method, key, response.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return response, nil
+}
+
+func (c *S3Client) objectURL(key string, query url.Values) (*url.URL, error) {
+	endpoint, parseError := url.Parse(c.Endpoint)
+	if parseError != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", parseError)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %+q", c.Endpoint)
+	}
+
+	objectPath := "/" + key
+	if c.VirtualHostedStyle {
+		endpoint.Host = c.Bucket + "." + endpoint.Host
+	} else {
+		objectPath = "/" + c.Bucket + objectPath
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + objectPath
+	endpoint.RawPath = s3URIEncode(endpoint.Path, false)
+	endpoint.RawQuery = canonicalS3Query(query)
+
+	return endpoint, nil
+}
+
+// signS3Request adds an AWS signature version 4 to the request, covering the host and all headers set so far
+func signS3Request(request *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.UTC().Format(s3DateFormat)
+	scope := strings.Join([]string{amzDate[:8], region, s3Service, "aws4_request"}, "/")
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range headerNames {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		s3URIEncode(request.URL.Path, false),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{s3SigningScheme, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, s3Service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3SigningScheme, accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalS3Query encodes the query sorted by key as required by the signature
+func canonicalS3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3URIEncode(key, true)+"="+s3URIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3URIEncode percent-encodes everything except unreserved characters; slashes are kept unless encodeSlash is set
+func s3URIEncode(value string, encodeSlash bool) string {
+	builder := &strings.Builder{}
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			builder.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			builder.WriteByte(b)
+		default:
+			fmt.Fprintf(builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
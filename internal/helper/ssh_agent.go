@@ -0,0 +1,46 @@
+package helper
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentSigner returns a signer backed by the ssh-agent listening on socketPath.
+// The key is selected by its SHA256 fingerprint or comment; an empty selector picks the first key offered by the agent.
+// Keys held by a KMS or HSM can be used through any agent compatible bridge, the private key never leaves the agent.
+func AgentSigner(socketPath, selector string) (ssh.Signer, error) {
+	if socketPath == "" {
+		return nil, fmt.Errorf("no ssh-agent socket configured")
+	}
+
+	conn, dialError := net.Dial("unix", socketPath)
+	if dialError != nil {
+		return nil, fmt.Errorf("cannot connect to ssh-agent: %w", dialError)
+	}
+
+	agentClient := agent.NewClient(conn)
+	keys, listError := agentClient.List()
+	if listError != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot list ssh-agent keys: %w", listError)
+	}
+
+	signers, signersError := agentClient.Signers()
+	if signersError != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot get ssh-agent signers: %w", signersError)
+	}
+
+	for i, key := range keys {
+		if selector == "" || selector == ssh.FingerprintSHA256(key) || selector == strings.TrimSpace(key.Comment) {
+			return signers[i], nil
+		}
+	}
+
+	conn.Close()
+	return nil, fmt.Errorf("no ssh-agent key matches %+q", selector)
+}
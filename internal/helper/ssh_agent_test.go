@@ -0,0 +1,77 @@
+package helper_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestAgentSigner(t *testing.T) {
+	keyring := agent.NewKeyring()
+	var fingerprints []string
+	for _, comment := range []string{"first", "second"} {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: privateKey, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+		publicKey, _ := ssh.NewPublicKey(privateKey.Public())
+		fingerprints = append(fingerprints, ssh.FingerprintSHA256(publicKey))
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, acceptError := listener.Accept()
+			if acceptError != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	for _, testCase := range []struct {
+		name                string
+		selector            string
+		expectedFingerprint string
+		shouldFail          bool
+	}{
+		{"EmptySelector", "", fingerprints[0], false},
+		{"Comment", "second", fingerprints[1], false},
+		{"Fingerprint", fingerprints[1], fingerprints[1], false},
+		{"Unknown", "third", "", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			signer, err := helper.AgentSigner(socketPath, testCase.selector)
+			if testCase.shouldFail {
+				if err == nil {
+					t.Fatal("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fingerprint := ssh.FingerprintSHA256(signer.PublicKey()); fingerprint != testCase.expectedFingerprint {
+				t.Errorf("expected %s, got %s", testCase.expectedFingerprint, fingerprint)
+			}
+			if _, err := signer.Sign(rand.Reader, []byte("data")); err != nil {
+				t.Errorf("signing failed: %s", err)
+			}
+		})
+	}
+}
@@ -1,10 +1,13 @@
 package helper
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -14,10 +17,14 @@ type SSHClient struct {
 	client *ssh.Client
 }
 
+// CustomSSHPort is the port sshd is moved to by cloud-init
 const CustomSSHPort = 2222
 
-func NewSSHClient(privateKeyStr, serverIP string, port uint16) (*SSHClient, error) {
-	client, err := connectSSH(privateKeyStr, serverIP, port)
+// SSHRetryDelay is the default delay between connection attempts while waiting for a server
+const SSHRetryDelay = 5 * time.Second
+
+func NewSSHClient(signer ssh.Signer, serverIP string, port uint16) (*SSHClient, error) {
+	client, err := connectSSH(signer, serverIP, port)
 	if err != nil {
 		return nil, err
 	}
@@ -25,18 +32,12 @@ func NewSSHClient(privateKeyStr, serverIP string, port uint16) (*SSHClient, erro
 	return &SSHClient{client}, nil
 }
 
-func connectSSH(privateKeyStr, serverIP string, port uint16) (*ssh.Client, error) {
-	// Parse the private key
-	privateKey, err := ssh.ParsePrivateKey([]byte(privateKeyStr))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
-	}
-
+func connectSSH(signer ssh.Signer, serverIP string, port uint16) (*ssh.Client, error) {
 	// Create an SSH client configuration
 	config := &ssh.ClientConfig{
 		User: "root",
 		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(privateKey),
+			ssh.PublicKeys(signer),
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		Timeout:         500 * time.Millisecond,
@@ -52,6 +53,29 @@ func connectSSH(privateKeyStr, serverIP string, port uint16) (*ssh.Client, error
 }
 
 func (c *SSHClient) RunCommand(ctx context.Context, command string) error {
+	return c.run(ctx, command, os.Stdout, os.Stderr)
+}
+
+// RunCommandOutput runs the command and returns its combined output; a non-zero exit status is reported as *ssh.ExitError
+func (c *SSHClient) RunCommandOutput(ctx context.Context, command string) ([]byte, error) {
+	output := &lockedWriter{writer: &bytes.Buffer{}}
+	err := c.run(ctx, command, output, output)
+	return output.writer.(*bytes.Buffer).Bytes(), err
+}
+
+// lockedWriter serializes the writes of stdout and stderr, which are copied concurrently
+type lockedWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(p)
+}
+
+func (c *SSHClient) run(ctx context.Context, command string, stdout, stderr io.Writer) error {
 	// Create a session
 	session, err := c.client.NewSession()
 	if err != nil {
@@ -59,15 +83,15 @@ func (c *SSHClient) RunCommand(ctx context.Context, command string) error {
 	}
 	defer session.Close()
 
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
+	session.Stdout = stdout
+	session.Stderr = stderr
 
 	// Run the command
 	err = session.Start(command)
 	if err != nil {
 		return fmt.Errorf("failed to run command: %w", err)
 	}
-	var waiter = make(chan error)
+	var waiter = make(chan error, 1)
 
 	// Wait for the command to finish or the context to be cancelled
 	go func() {
@@ -77,6 +101,9 @@ func (c *SSHClient) RunCommand(ctx context.Context, command string) error {
 	select {
 	case <-ctx.Done():
 		session.Signal(ssh.SIGTERM)
+		// ensure the output is not written anymore after returning
+		session.Close()
+		<-waiter
 		fmt.Println("")
 		return ctx.Err()
 	case err := <-waiter:
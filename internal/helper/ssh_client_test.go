@@ -0,0 +1,140 @@
+package helper
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakessh"
+)
+
+// testRetryDelay keeps waiting tests fast
+const testRetryDelay = 50 * time.Millisecond
+
+// startSSHServer starts a fake ssh server accepting the returned signer
+func startSSHServer(t *testing.T, options fakessh.Options) (*fakessh.Server, ssh.Signer) {
+	t.Helper()
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	assert.NoError(t, err)
+
+	options.AuthorizedKeys = append(options.AuthorizedKeys, signer.PublicKey())
+	server, err := fakessh.Start(options)
+	assert.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	return server, signer
+}
+
+func TestSSHClientRunCommandOutput(t *testing.T) {
+	server, signer := startSSHServer(t, fakessh.Options{})
+	client, err := NewSSHClient(signer, server.Host(), server.Port())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	for _, testCase := range []struct {
+		name               string
+		command            string
+		expectedOutput     string
+		expectedExitStatus int
+	}{
+		{"stdout", "echo hello", "hello\n", 0},
+		{"stderr", "echo failed >&2", "failed\n", 0},
+		{"exit status", "echo partial; exit 3", "partial\n", 3},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			output, err := client.RunCommandOutput(context.Background(), testCase.command)
+			assert.Equal(t, testCase.expectedOutput, string(output))
+
+			var exitError *ssh.ExitError
+			if testCase.expectedExitStatus == 0 {
+				assert.NoError(t, err)
+			} else if assert.ErrorAs(t, err, &exitError) {
+				assert.Equal(t, testCase.expectedExitStatus, exitError.ExitStatus())
+			}
+		})
+	}
+}
+
+func TestSSHClientCancel(t *testing.T) {
+	server, signer := startSSHServer(t, fakessh.Options{})
+	client, err := NewSSHClient(signer, server.Host(), server.Port())
+	assert.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err = client.RunCommandOutput(ctx, "sleep 10")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
+
+func TestNewSSHClientUnauthorized(t *testing.T) {
+	server, _ := startSSHServer(t, fakessh.Options{})
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(privateKey)
+
+	_, err := NewSSHClient(otherSigner, server.Host(), server.Port())
+	assert.Error(t, err)
+}
+
+func TestWaitReachable(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		bootDelay     time.Duration
+		dropSessions  int
+		expectedError bool
+	}{
+		{"reachable", 0, 0, false},
+		{"slow boot", 300 * time.Millisecond, 0, false},
+		{"dropped connections", 0, 2, false},
+		{"never booted", time.Hour, 0, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			server, signer := startSSHServer(t, fakessh.Options{BootDelay: testCase.bootDelay})
+			server.DropSessions(testCase.dropSessions)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := WaitReachable(ctx, signer, server.Host(), server.Port(), testRetryDelay)
+			assert.Equal(t, testCase.expectedError, err != nil)
+		})
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		cloudInit     string
+		probes        []ReadinessProbe
+		expectedError bool
+	}{
+		{"cloud-init done", "echo 'status: done'", []ReadinessProbe{CloudInitProbe{}}, false},
+		{"cloud-init warnings", "echo 'status: degraded done'; exit 2", []ReadinessProbe{CloudInitProbe{}}, false},
+		{"cloud-init failed", "echo 'status: error'; exit 1", []ReadinessProbe{CloudInitProbe{}}, true},
+		{"command succeeds", "", []ReadinessProbe{CommandProbe{Command: "test -d bin"}}, false},
+		{"command fails", "", []ReadinessProbe{CommandProbe{Command: "false"}}, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			server, signer := startSSHServer(t, fakessh.Options{Stubs: map[string]string{"cloud-init": "#!/bin/sh\n" + testCase.cloudInit + "\n"}})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			started := time.Now()
+			err := WaitReady(ctx, signer, server.Host(), server.Port(), testRetryDelay, testCase.probes...)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			// a failed cloud-init run stops waiting immediately
+			if testCase.name == "cloud-init failed" {
+				assert.False(t, errors.Is(err, context.DeadlineExceeded))
+				assert.Less(t, time.Since(started), 500*time.Millisecond)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package helper
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
@@ -11,11 +14,41 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func GenerateSSHKeyPair() (string, string, error) {
-	// Generate the ECDSA key pair with P-256 curve
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+type SSHKeyType string
+
+const (
+	SSHKeyTypeED25519   SSHKeyType = "ed25519"
+	SSHKeyTypeECDSAP256 SSHKeyType = "ecdsa-p256"
+	SSHKeyTypeECDSAP384 SSHKeyType = "ecdsa-p384"
+	SSHKeyTypeRSA4096   SSHKeyType = "rsa-4096"
+)
+
+// SSHKeyTypes lists all supported key types, the first one being the default
+var SSHKeyTypes = []string{
+	string(SSHKeyTypeED25519),
+	string(SSHKeyTypeECDSAP256),
+	string(SSHKeyTypeECDSAP384),
+	string(SSHKeyTypeRSA4096),
+}
+
+func GenerateSSHKeyPair(keyType SSHKeyType) (string, string, error) {
+	var privKey crypto.Signer
+	var err error
+
+	switch keyType {
+	case SSHKeyTypeED25519, "":
+		_, privKey, err = ed25519.GenerateKey(rand.Reader)
+	case SSHKeyTypeECDSAP256:
+		privKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SSHKeyTypeECDSAP384:
+		privKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case SSHKeyTypeRSA4096:
+		privKey, err = rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return "", "", fmt.Errorf("unsupported ssh key type %+q", keyType)
+	}
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate ecdsa key pair: %v", err)
+		return "", "", fmt.Errorf("failed to generate %s key pair: %v", keyType, err)
 	}
 
 	// Marshal the private key into PKCS8 format
@@ -31,7 +64,7 @@ func GenerateSSHKeyPair() (string, string, error) {
 	})
 
 	// Convert the public key to SSH format
-	sshPubKey, err := ssh.NewPublicKey(&privKey.PublicKey)
+	sshPubKey, err := ssh.NewPublicKey(privKey.Public())
 	if err != nil {
 		return "", "", fmt.Errorf("failed to convert public key to ssh format: %v", err)
 	}
@@ -1,26 +1,54 @@
 package helper_test
 
 import (
-	"fmt"
 	"testing"
 
+	"golang.org/x/crypto/ssh"
+
 	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
 )
 
 func TestGenerateSSHKeyPair(t *testing.T) {
-	privKey, pubKey, err := helper.GenerateSSHKeyPair()
-	if err != nil {
-		t.Fatal(err)
-	}
+	for _, testCase := range []struct {
+		keyType           helper.SSHKeyType
+		expectedAlgorithm string
+		shouldFail        bool
+	}{
+		{"", ssh.KeyAlgoED25519, false},
+		{helper.SSHKeyTypeED25519, ssh.KeyAlgoED25519, false},
+		{helper.SSHKeyTypeECDSAP256, ssh.KeyAlgoECDSA256, false},
+		{helper.SSHKeyTypeECDSAP384, ssh.KeyAlgoECDSA384, false},
+		{helper.SSHKeyTypeRSA4096, ssh.KeyAlgoRSA, false},
+		{"dsa", "", true},
+	} {
+		t.Run(string(testCase.keyType), func(t *testing.T) {
+			privKey, pubKey, err := helper.GenerateSSHKeyPair(testCase.keyType)
+			if testCase.shouldFail {
+				if err == nil {
+					t.Fatal("expected error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
 
-	if privKey == "" {
-		t.Fatal("private key is empty")
-	}
+			signer, err := ssh.ParsePrivateKey([]byte(privKey))
+			if err != nil {
+				t.Fatalf("private key is not parsable: %s", err)
+			}
 
-	if pubKey == "" {
-		t.Fatal("public key is empty")
-	}
+			parsedPubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubKey))
+			if err != nil {
+				t.Fatalf("public key is not parsable: %s", err)
+			}
 
-	fmt.Println(privKey)
-	fmt.Println(pubKey)
+			if parsedPubKey.Type() != testCase.expectedAlgorithm {
+				t.Errorf("expected key type %s, got %s", testCase.expectedAlgorithm, parsedPubKey.Type())
+			}
+			if ssh.FingerprintSHA256(parsedPubKey) != ssh.FingerprintSHA256(signer.PublicKey()) {
+				t.Errorf("public key does not belong to private key")
+			}
+		})
+	}
 }
@@ -2,36 +2,127 @@ package helper
 
 import (
 	"encoding/json"
-	"os"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type State struct {
 	SSHPrivateKey string
-	ServerAddress string
+	// SSHAgentSocket and SSHKeyFingerprint are set instead of SSHPrivateKey if the key is held by an ssh-agent
+	SSHAgentSocket    string `json:",omitempty"`
+	SSHKeyFingerprint string `json:",omitempty"`
+	ServerAddress     string
+	// SSHPort is unset in states written by older versions, which always used CustomSSHPort
+	SSHPort uint16 `json:",omitempty"`
 }
 
-const StatePath = "state.json"
+// Port returns the ssh port of the server
+func (s *State) Port() uint16 {
+	if s.SSHPort == 0 {
+		return CustomSSHPort
+	}
+	return s.SSHPort
+}
 
-func (s *State) WriteToFile(path string) error {
-	os.Truncate(path, 0)
-	fh, fileOpenError := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
-	if fileOpenError != nil {
-		return fileOpenError
+// StateVersion is the current version of the persisted state schema; it has to be increased on incompatible changes
+const StateVersion = 1
+
+// stateEnvelope is the persisted representation of a State; either State or the encrypted fields are set
+type stateEnvelope struct {
+	Version    int
+	Encryption string `json:",omitempty"`
+	Salt       []byte `json:",omitempty"`
+	Nonce      []byte `json:",omitempty"`
+	Data       []byte `json:",omitempty"`
+	State      *State `json:",omitempty"`
+}
+
+// Signer returns the signer used to authenticate against the job server
+func (s *State) Signer() (ssh.Signer, error) {
+	if s.SSHPrivateKey != "" {
+		signer, parseError := ssh.ParsePrivateKey([]byte(s.SSHPrivateKey))
+		if parseError != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", parseError)
+		}
+		return signer, nil
+	}
+	if s.SSHAgentSocket != "" {
+		return AgentSigner(s.SSHAgentSocket, s.SSHKeyFingerprint)
 	}
 
-	return json.NewEncoder(fh).Encode(s)
+	return nil, fmt.Errorf("no ssh credentials in state")
 }
 
-func ReadStateFromFile(path string) (*State, error) {
-	fh, fileOpenError := os.Open(path)
-	if fileOpenError != nil {
-		return nil, fileOpenError
+// Marshal serializes the state; it gets encrypted if a secret is provided
+func (s *State) Marshal(secret string) ([]byte, error) {
+	envelope := stateEnvelope{Version: StateVersion}
+
+	if secret == "" {
+		envelope.State = s
+		return json.Marshal(envelope)
+	}
+
+	plaintext, marshalError := json.Marshal(s)
+	if marshalError != nil {
+		return nil, marshalError
+	}
+
+	var sealError error
+	envelope.Encryption = stateEncryptionSecretbox
+	envelope.Salt, envelope.Nonce, envelope.Data, sealError = sealState(plaintext, secret)
+	if sealError != nil {
+		return nil, sealError
 	}
 
-	var state State
-	if err := json.NewDecoder(fh).Decode(&state); err != nil {
+	return json.Marshal(envelope)
+}
+
+// UnmarshalState parses a state serialized by State.Marshal
+func UnmarshalState(data []byte, secret string) (*State, error) {
+	var envelope stateEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
 		return nil, err
 	}
 
-	return &state, nil
+	switch {
+	case envelope.Version == 0:
+		// state written before versioning was introduced, consisting of the plain State fields
+		if secret != "" {
+			return nil, fmt.Errorf("state is not encrypted, but a state secret is configured")
+		}
+		var state State
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, err
+		}
+		return &state, nil
+	case envelope.Version > StateVersion:
+		return nil, fmt.Errorf("state version %d is newer than supported version %d", envelope.Version, StateVersion)
+	}
+
+	switch envelope.Encryption {
+	case "":
+		if secret != "" {
+			return nil, fmt.Errorf("state is not encrypted, but a state secret is configured")
+		}
+		if envelope.State == nil {
+			return nil, fmt.Errorf("state is empty")
+		}
+		return envelope.State, nil
+	case stateEncryptionSecretbox:
+		if secret == "" {
+			return nil, fmt.Errorf("state is encrypted, but no state secret is configured")
+		}
+		plaintext, openError := openState(envelope.Salt, envelope.Nonce, envelope.Data, secret)
+		if openError != nil {
+			return nil, openError
+		}
+		var state State
+		if err := json.Unmarshal(plaintext, &state); err != nil {
+			return nil, err
+		}
+		return &state, nil
+	}
+
+	return nil, fmt.Errorf("unsupported state encryption %+q", envelope.Encryption)
 }
@@ -0,0 +1,125 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	StateFileName     = "state.json"
+	stateLockFileName = "state.lock"
+	stateLockTimeout  = 30 * time.Second
+)
+
+// StateBackend persists serialized job states; missing states are reported as os.ErrNotExist
+type StateBackend interface {
+	Read(jobID string) ([]byte, error)
+	Write(jobID string, data []byte) error
+	// Delete removes the state of a job; deleting a missing state is not an error
+	Delete(jobID string) error
+	List() ([]string, error)
+}
+
+// LocalStateBackend stores job states below a base directory, using one directory per job
+type LocalStateBackend struct {
+	BaseDir string
+}
+
+// DefaultStateDir returns the default state base directory following the XDG base directory specification
+func DefaultStateDir() string {
+	if stateHome := os.Getenv("XDG_STATE_HOME"); stateHome != "" {
+		return filepath.Join(stateHome, "hmp")
+	}
+	if home, homeError := os.UserHomeDir(); homeError == nil {
+		return filepath.Join(home, ".local", "state", "hmp")
+	}
+	return "hmp-state"
+}
+
+// Path returns the state file path of the given job
+func (b *LocalStateBackend) Path(jobID string) string {
+	return filepath.Join(b.BaseDir, jobID, StateFileName)
+}
+
+func (b *LocalStateBackend) Read(jobID string) ([]byte, error) {
+	lock, lockError := b.lock(jobID)
+	if lockError != nil {
+		return nil, lockError
+	}
+	defer lock.Release()
+
+	return os.ReadFile(b.Path(jobID))
+}
+
+func (b *LocalStateBackend) Write(jobID string, data []byte) error {
+	if err := os.MkdirAll(filepath.Join(b.BaseDir, jobID), 0700); err != nil {
+		return err
+	}
+
+	lock, lockError := b.lock(jobID)
+	if lockError != nil {
+		return lockError
+	}
+	defer lock.Release()
+
+	return WriteFileAtomic(b.Path(jobID), data, 0600)
+}
+
+func (b *LocalStateBackend) Delete(jobID string) error {
+	lock, lockError := b.lock(jobID)
+	if errors.Is(lockError, os.ErrNotExist) {
+		return nil
+	}
+	if lockError != nil {
+		return lockError
+	}
+
+	removeError := os.Remove(b.Path(jobID))
+	lock.Release()
+	if removeError != nil && !errors.Is(removeError, os.ErrNotExist) {
+		return removeError
+	}
+
+	// fails silently if another process has written a new state in the meantime
+	os.Remove(filepath.Join(b.BaseDir, jobID))
+	return nil
+}
+
+func (b *LocalStateBackend) List() ([]string, error) {
+	entries, readDirError := os.ReadDir(b.BaseDir)
+	if errors.Is(readDirError, os.ErrNotExist) {
+		return nil, nil
+	}
+	if readDirError != nil {
+		return nil, readDirError
+	}
+
+	var jobIDs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || validateJobID(entry.Name()) != nil {
+			continue
+		}
+		if _, statError := os.Stat(b.Path(entry.Name())); statError == nil {
+			jobIDs = append(jobIDs, entry.Name())
+		}
+	}
+	sort.Strings(jobIDs)
+
+	return jobIDs, nil
+}
+
+func (b *LocalStateBackend) lock(jobID string) (*FileLock, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateLockTimeout)
+	defer cancel()
+
+	lock, lockError := AcquireFileLock(ctx, filepath.Join(b.BaseDir, jobID, stateLockFileName))
+	if errors.Is(lockError, os.ErrNotExist) {
+		return nil, fmt.Errorf("no state found for job %s: %w", jobID, os.ErrNotExist)
+	}
+	return lock, lockError
+}
@@ -0,0 +1,47 @@
+package helper
+
+import (
+	"sort"
+	"strings"
+)
+
+// S3StateBackend stores job states as objects in an S3-compatible bucket, so all runner managers can access them
+type S3StateBackend struct {
+	Client *S3Client
+	// Prefix is prepended to all object keys, e.g. "hmp/"
+	Prefix string
+}
+
+func (b *S3StateBackend) key(jobID string) string {
+	return b.Prefix + jobID + "/" + StateFileName
+}
+
+func (b *S3StateBackend) Read(jobID string) ([]byte, error) {
+	return b.Client.GetObject(b.key(jobID))
+}
+
+func (b *S3StateBackend) Write(jobID string, data []byte) error {
+	return b.Client.PutObject(b.key(jobID), data)
+}
+
+func (b *S3StateBackend) Delete(jobID string) error {
+	return b.Client.DeleteObject(b.key(jobID))
+}
+
+func (b *S3StateBackend) List() ([]string, error) {
+	prefixes, listError := b.Client.ListPrefixes(b.Prefix)
+	if listError != nil {
+		return nil, listError
+	}
+
+	var jobIDs []string
+	for _, prefix := range prefixes {
+		jobID := strings.TrimSuffix(strings.TrimPrefix(prefix, b.Prefix), "/")
+		if validateJobID(jobID) == nil {
+			jobIDs = append(jobIDs, jobID)
+		}
+	}
+	sort.Strings(jobIDs)
+
+	return jobIDs, nil
+}
@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const stateEncryptionSecretbox = "secretbox-scrypt"
+
+// deriveStateKey derives the secretbox key from the runner-level state secret
+func deriveStateKey(secret string, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key([]byte(secret), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive state key: %w", err)
+	}
+
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// sealState encrypts the plaintext with a key derived from secret and a random salt
+func sealState(plaintext []byte, secret string) (salt []byte, nonce []byte, box []byte, err error) {
+	salt = make([]byte, 16)
+	var nonceArray [24]byte
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := rand.Read(nonceArray[:]); err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := deriveStateKey(secret, salt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return salt, nonceArray[:], secretbox.Seal(nil, plaintext, &nonceArray, key), nil
+}
+
+// openState decrypts a box created by sealState
+func openState(salt, nonce, box []byte, secret string) ([]byte, error) {
+	if len(nonce) != 24 {
+		return nil, fmt.Errorf("invalid state nonce")
+	}
+	var nonceArray [24]byte
+	copy(nonceArray[:], nonce)
+
+	key, err := deriveStateKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, ok := secretbox.Open(nil, box, &nonceArray, key)
+	if !ok {
+		return nil, fmt.Errorf("cannot decrypt state: wrong state secret or corrupted state")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,71 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var jobIDValidator = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ErrPlaintextPrivateKey is returned on writing a state containing a private key without a state secret
+var ErrPlaintextPrivateKey = errors.New("refusing to store the ssh private key unencrypted, configure a state secret")
+
+// StateStore serializes job states and persists them using the configured backend
+type StateStore struct {
+	Backend StateBackend
+	Secret  string
+	// InsecurePlaintext allows storing private keys without a secret, so they are readable by anyone able to read the backend
+	InsecurePlaintext bool
+}
+
+func (s *StateStore) Write(jobID string, state *State) error {
+	if err := validateJobID(jobID); err != nil {
+		return err
+	}
+	if s.Secret == "" && state.SSHPrivateKey != "" && !s.InsecurePlaintext {
+		return ErrPlaintextPrivateKey
+	}
+
+	data, marshalError := state.Marshal(s.Secret)
+	if marshalError != nil {
+		return marshalError
+	}
+
+	return s.Backend.Write(jobID, data)
+}
+
+func (s *StateStore) Read(jobID string) (*State, error) {
+	if err := validateJobID(jobID); err != nil {
+		return nil, err
+	}
+
+	data, readError := s.Backend.Read(jobID)
+	if readError != nil {
+		return nil, readError
+	}
+
+	return UnmarshalState(data, s.Secret)
+}
+
+// Remove deletes the state of the given job; removing a non-existing state is not an error
+func (s *StateStore) Remove(jobID string) error {
+	if err := validateJobID(jobID); err != nil {
+		return err
+	}
+
+	return s.Backend.Delete(jobID)
+}
+
+// List returns the ids of all jobs with a persisted state
+func (s *StateStore) List() ([]string, error) {
+	return s.Backend.List()
+}
+
+// validateJobID ensures the job id is usable as a single path element
+func validateJobID(jobID string) error {
+	if !jobIDValidator.MatchString(jobID) {
+		return fmt.Errorf("job id is invalid: %+q", jobID)
+	}
+	return nil
+}
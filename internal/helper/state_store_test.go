@@ -0,0 +1,70 @@
+package helper_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestStateStore(t *testing.T) {
+	store := &helper.StateStore{Backend: &helper.LocalStateBackend{BaseDir: t.TempDir()}, Secret: "secret"}
+
+	jobIDs, err := store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, jobIDs)
+
+	for _, jobID := range []string{"2", "1"} {
+		assert.NoError(t, store.Write(jobID, &helper.State{ServerAddress: "192.0.2." + jobID}))
+	}
+
+	jobIDs, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, jobIDs)
+
+	state, err := store.Read("2")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.2", state.ServerAddress)
+
+	assert.NoError(t, store.Remove("2"))
+	assert.NoError(t, store.Remove("2"), "removing a missing state must be idempotent")
+
+	_, err = store.Read("2")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	jobIDs, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, jobIDs)
+}
+
+func TestStateStorePlaintextPrivateKey(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		store         *helper.StateStore
+		state         *helper.State
+		expectedError bool
+	}{
+		{"encrypted", &helper.StateStore{Secret: "secret"}, &helper.State{SSHPrivateKey: "key"}, false},
+		{"plaintext", &helper.StateStore{}, &helper.State{SSHPrivateKey: "key"}, true},
+		{"plaintext opt-out", &helper.StateStore{InsecurePlaintext: true}, &helper.State{SSHPrivateKey: "key"}, false},
+		{"agent key", &helper.StateStore{}, &helper.State{SSHAgentSocket: "/run/agent.sock"}, false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.store.Backend = &helper.LocalStateBackend{BaseDir: t.TempDir()}
+			err := testCase.store.Write("1", testCase.state)
+			assert.Equal(t, testCase.expectedError, errors.Is(err, helper.ErrPlaintextPrivateKey))
+			assert.Equal(t, testCase.expectedError, err != nil)
+		})
+	}
+}
+
+func TestStateStoreInvalidJobID(t *testing.T) {
+	store := &helper.StateStore{Backend: &helper.LocalStateBackend{BaseDir: t.TempDir()}}
+
+	for _, jobID := range []string{"", "..", "../1", "1/2"} {
+		assert.Error(t, store.Write(jobID, &helper.State{}), jobID)
+	}
+}
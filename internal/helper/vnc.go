@@ -0,0 +1,395 @@
+package helper
+
+import (
+	"bufio"
+	"context"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"net/http"
+	"strings"
+)
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xa
+
+	rfbVersion            = "RFB 003.008\n"
+	rfbSecurityNone       = 1
+	rfbSecurityVNC        = 2
+	rfbFramebufferUpdate  = 0
+	rfbSetColourMap       = 1
+	rfbBell               = 2
+	rfbServerCutText      = 3
+	rfbEncodingRaw        = 0
+	rfbMaxFramebufferSize = 8192
+)
+
+// CaptureVNCScreen connects to a VNC console tunneled through a websocket, as offered by cloud providers, and returns
+// the current screen content
+func CaptureVNCScreen(ctx context.Context, url, password string) (image.Image, error) {
+	conn, dialError := dialWebsocket(ctx, url)
+	if dialError != nil {
+		return nil, dialError
+	}
+	defer conn.Close()
+	// the connection does not support deadlines, so it is closed to abort blocking reads
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	screen, captureError := captureRFBScreen(conn, password)
+	if captureError != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return screen, captureError
+}
+
+// websocketConn exchanges binary messages as a byte stream; only frames sent by the client are masked
+type websocketConn struct {
+	rw      io.ReadWriteCloser
+	reader  *bufio.Reader
+	client  bool
+	pending []byte
+}
+
+func dialWebsocket(ctx context.Context, url string) (*websocketConn, error) {
+	url = strings.Replace(strings.Replace(url, "wss://", "https://", 1), "ws://", "http://", 1)
+	request, requestError := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if requestError != nil {
+		return nil, requestError
+	}
+	nonce := make([]byte, 16)
+	if _, randomError := rand.Read(nonce); randomError != nil {
+		return nil, randomError
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	request.Header.Set("Sec-WebSocket-Protocol", "binary")
+
+	response, responseError := http.DefaultClient.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	rw, upgraded := response.Body.(io.ReadWriteCloser)
+	if response.StatusCode != http.StatusSwitchingProtocols || !upgraded {
+		response.Body.Close()
+		return nil, fmt.Errorf("websocket upgrade failed: %s", response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		rw.Close()
+		return nil, errors.New("websocket upgrade failed: invalid accept key")
+	}
+	return &websocketConn{rw: rw, reader: bufio.NewReader(rw), client: true}, nil
+}
+
+// websocketAccept returns the accept key the server answers the handshake key with
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		opcode, payload, frameError := c.readFrame()
+		if frameError != nil {
+			return 0, frameError
+		}
+		switch opcode {
+		case websocketOpBinary, websocketOpText, websocketOpContinuation:
+			c.pending = payload
+		case websocketOpClose:
+			return 0, io.EOF
+		case websocketOpPing:
+			if pongError := c.writeFrame(websocketOpPong, payload); pongError != nil {
+				return 0, pongError
+			}
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *websocketConn) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, readError := io.ReadFull(c.reader, header); readError != nil {
+		return 0, nil, readError
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, readError := io.ReadFull(c.reader, extended); readError != nil {
+			return 0, nil, readError
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, readError := io.ReadFull(c.reader, extended); readError != nil {
+			return 0, nil, readError
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if length > 64<<20 {
+		return 0, nil, fmt.Errorf("websocket frame of %d bytes exceeds the limit", length)
+	}
+	var mask []byte
+	if header[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, readError := io.ReadFull(c.reader, mask); readError != nil {
+			return 0, nil, readError
+		}
+	}
+	payload := make([]byte, length)
+	if _, readError := io.ReadFull(c.reader, payload); readError != nil {
+		return 0, nil, readError
+	}
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	return header[0] & 0x0f, payload, nil
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if writeError := c.writeFrame(websocketOpBinary, p); writeError != nil {
+		return 0, writeError
+	}
+	return len(p), nil
+}
+
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if c.client {
+		mask := make([]byte, 4)
+		if _, randomError := rand.Read(mask); randomError != nil {
+			return randomError
+		}
+		frame = append(frame, mask...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, writeError := c.rw.Write(frame)
+	return writeError
+}
+
+func (c *websocketConn) Close() error {
+	return c.rw.Close()
+}
+
+// captureRFBScreen performs the RFB handshake and requests the complete framebuffer in raw encoding
+func captureRFBScreen(conn io.ReadWriter, password string) (image.Image, error) {
+	serverVersion := make([]byte, len(rfbVersion))
+	if _, readError := io.ReadFull(conn, serverVersion); readError != nil {
+		return nil, readError
+	}
+	if !strings.HasPrefix(string(serverVersion), "RFB ") {
+		return nil, fmt.Errorf("unexpected vnc protocol version %+q", serverVersion)
+	}
+	if _, writeError := io.WriteString(conn, rfbVersion); writeError != nil {
+		return nil, writeError
+	}
+
+	if securityError := rfbAuthenticate(conn, password); securityError != nil {
+		return nil, securityError
+	}
+
+	// shared, so other console sessions are not disconnected
+	if _, writeError := conn.Write([]byte{1}); writeError != nil {
+		return nil, writeError
+	}
+	serverInit := make([]byte, 24)
+	if _, readError := io.ReadFull(conn, serverInit); readError != nil {
+		return nil, readError
+	}
+	width, height := int(binary.BigEndian.Uint16(serverInit[0:])), int(binary.BigEndian.Uint16(serverInit[2:]))
+	if _, readError := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(serverInit[20:]))); readError != nil {
+		return nil, readError
+	}
+
+	// 32 bit little endian true color, so raw pixels are stored as blue, green, red, padding
+	setPixelFormat := []byte{0, 0, 0, 0, 32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0}
+	setEncodings := binary.BigEndian.AppendUint32([]byte{2, 0, 0, 1}, rfbEncodingRaw)
+	updateRequest := []byte{3, 0, 0, 0, 0, 0}
+	updateRequest = binary.BigEndian.AppendUint16(updateRequest, uint16(width))
+	updateRequest = binary.BigEndian.AppendUint16(updateRequest, uint16(height))
+	for _, message := range [][]byte{setPixelFormat, setEncodings, updateRequest} {
+		if _, writeError := conn.Write(message); writeError != nil {
+			return nil, writeError
+		}
+	}
+
+	screen := image.NewRGBA(image.Rect(0, 0, width, height))
+	for {
+		messageType := make([]byte, 1)
+		if _, readError := io.ReadFull(conn, messageType); readError != nil {
+			return nil, readError
+		}
+		switch messageType[0] {
+		case rfbFramebufferUpdate:
+			return screen, readRFBFramebufferUpdate(conn, screen)
+		case rfbSetColourMap:
+			header := make([]byte, 5)
+			if _, readError := io.ReadFull(conn, header); readError != nil {
+				return nil, readError
+			}
+			if _, readError := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint16(header[3:]))*6); readError != nil {
+				return nil, readError
+			}
+		case rfbBell:
+		case rfbServerCutText:
+			header := make([]byte, 7)
+			if _, readError := io.ReadFull(conn, header); readError != nil {
+				return nil, readError
+			}
+			if _, readError := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(header[3:]))); readError != nil {
+				return nil, readError
+			}
+		default:
+			return nil, fmt.Errorf("unexpected vnc message type %d", messageType[0])
+		}
+	}
+}
+
+// rfbAuthenticate negotiates the security type; vnc authentication is used if the server requires it
+func rfbAuthenticate(conn io.ReadWriter, password string) error {
+	count := make([]byte, 1)
+	if _, readError := io.ReadFull(conn, count); readError != nil {
+		return readError
+	}
+	if count[0] == 0 {
+		return fmt.Errorf("vnc connection refused: %s", readRFBReason(conn))
+	}
+	securityTypes := make([]byte, count[0])
+	if _, readError := io.ReadFull(conn, securityTypes); readError != nil {
+		return readError
+	}
+
+	var securityType byte
+	for _, offered := range securityTypes {
+		if offered == rfbSecurityNone || (offered == rfbSecurityVNC && securityType != rfbSecurityNone) {
+			securityType = offered
+		}
+	}
+	if securityType == 0 {
+		return fmt.Errorf("no supported vnc security type offered: %v", securityTypes)
+	}
+	if _, writeError := conn.Write([]byte{securityType}); writeError != nil {
+		return writeError
+	}
+
+	if securityType == rfbSecurityVNC {
+		challenge := make([]byte, 16)
+		if _, readError := io.ReadFull(conn, challenge); readError != nil {
+			return readError
+		}
+		if _, writeError := conn.Write(vncAuthResponse(challenge, password)); writeError != nil {
+			return writeError
+		}
+	}
+
+	result := make([]byte, 4)
+	if _, readError := io.ReadFull(conn, result); readError != nil {
+		return readError
+	}
+	if binary.BigEndian.Uint32(result) != 0 {
+		return fmt.Errorf("vnc authentication failed: %s", readRFBReason(conn))
+	}
+	return nil
+}
+
+// vncAuthResponse encrypts the challenge using DES with the password as key; vnc expects the bits of each key byte
+// in reversed order
+func vncAuthResponse(challenge []byte, password string) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key {
+		key[i] = bits.Reverse8(b)
+	}
+	cipher, _ := des.NewCipher(key)
+	response := make([]byte, len(challenge))
+	for i := 0; i+8 <= len(challenge); i += 8 {
+		cipher.Encrypt(response[i:i+8], challenge[i:i+8])
+	}
+	return response
+}
+
+func readRFBReason(conn io.Reader) string {
+	length := make([]byte, 4)
+	if _, readError := io.ReadFull(conn, length); readError != nil {
+		return "no reason given"
+	}
+	reason := make([]byte, min(binary.BigEndian.Uint32(length), 1024))
+	_, _ = io.ReadFull(conn, reason)
+	return string(reason)
+}
+
+func readRFBFramebufferUpdate(conn io.Reader, screen *image.RGBA) error {
+	header := make([]byte, 3)
+	if _, readError := io.ReadFull(conn, header); readError != nil {
+		return readError
+	}
+	for range binary.BigEndian.Uint16(header[1:]) {
+		rectangle := make([]byte, 12)
+		if _, readError := io.ReadFull(conn, rectangle); readError != nil {
+			return readError
+		}
+		x, y := int(binary.BigEndian.Uint16(rectangle[0:])), int(binary.BigEndian.Uint16(rectangle[2:]))
+		width, height := int(binary.BigEndian.Uint16(rectangle[4:])), int(binary.BigEndian.Uint16(rectangle[6:]))
+		if encoding := int32(binary.BigEndian.Uint32(rectangle[8:])); encoding != rfbEncodingRaw {
+			return fmt.Errorf("unexpected vnc encoding %d", encoding)
+		}
+		if width > rfbMaxFramebufferSize || height > rfbMaxFramebufferSize {
+			return fmt.Errorf("vnc rectangle of %dx%d exceeds the limit", width, height)
+		}
+		pixels := make([]byte, width*height*4)
+		if _, readError := io.ReadFull(conn, pixels); readError != nil {
+			return readError
+		}
+		for row := range height {
+			for column := range width {
+				if !image.Pt(x+column, y+row).In(screen.Rect) {
+					continue
+				}
+				pixel := pixels[(row*width+column)*4:]
+				offset := screen.PixOffset(x+column, y+row)
+				copy(screen.Pix[offset:offset+4], []byte{pixel[2], pixel[1], pixel[0], 0xff})
+			}
+		}
+	}
+	return nil
+}
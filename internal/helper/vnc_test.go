@@ -0,0 +1,81 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/color"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startVNCServer serves a 2x1 screen with a red and a blue pixel through a websocket
+func startVNCServer(t *testing.T, password string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawConn, rw, _ := w.(http.Hijacker).Hijack()
+		defer rawConn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		_ = rw.Flush()
+		conn := &websocketConn{rw: rawConn, reader: bufio.NewReader(rw)}
+
+		_, _ = io.WriteString(conn, rfbVersion)
+		clientVersion := make([]byte, len(rfbVersion))
+		_, _ = io.ReadFull(conn, clientVersion)
+		_, _ = conn.Write([]byte{1, rfbSecurityVNC})
+		securityType := make([]byte, 1)
+		_, _ = io.ReadFull(conn, securityType)
+		challenge := bytes.Repeat([]byte{0x42}, 16)
+		_, _ = conn.Write(challenge)
+		response := make([]byte, 16)
+		_, _ = io.ReadFull(conn, response)
+		if !bytes.Equal(response, vncAuthResponse(challenge, password)) {
+			_, _ = conn.Write([]byte{0, 0, 0, 1, 0, 0, 0, 5})
+			_, _ = io.WriteString(conn, "wrong")
+			return
+		}
+		_, _ = conn.Write([]byte{0, 0, 0, 0})
+
+		clientInit := make([]byte, 1)
+		_, _ = io.ReadFull(conn, clientInit)
+		serverInit := append([]byte{0, 2, 0, 1}, make([]byte, 16)...)
+		serverInit = binary.BigEndian.AppendUint32(serverInit, 4)
+		_, _ = conn.Write(append(serverInit, "test"...))
+		// set pixel format, set encodings and framebuffer update request
+		_, _ = io.ReadFull(conn, make([]byte, 20+8+10))
+
+		_, _ = conn.Write([]byte{rfbBell})
+		_, _ = conn.Write([]byte{rfbFramebufferUpdate, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0})
+		_, _ = conn.Write([]byte{0, 0, 0xff, 0, 0xff, 0, 0, 0})
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestCaptureVNCScreen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := startVNCServer(t, "secret")
+
+	screen, err := CaptureVNCScreen(ctx, url, "secret")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, screen.Bounds().Dx())
+		assert.Equal(t, 1, screen.Bounds().Dy())
+		assert.Equal(t, color.RGBA{R: 0xff, A: 0xff}, screen.At(0, 0))
+		assert.Equal(t, color.RGBA{B: 0xff, A: 0xff}, screen.At(1, 0))
+	}
+
+	_, err = CaptureVNCScreen(ctx, url, "wrong")
+	assert.ErrorContains(t, err, "vnc authentication failed: wrong")
+
+	_, err = CaptureVNCScreen(ctx, "ws://127.0.0.1:1", "secret")
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package hetzner
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sccacheWrapper runs sccache with the root-only configuration and credentials written by cloud-init
+const sccacheWrapper = "/usr/local/bin/hmp-sccache"
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// BuildCacheOptions configure caching layers installed on the job server through cloud-init
+type BuildCacheOptions struct {
+	S3Endpoint string
+	S3Bucket   string
+	S3Region   string
+	// S3Prefix is the object key prefix of the runner; the cache of each project is nested below it
+	S3Prefix    string
+	S3AccessKey string
+	S3SecretKey string
+	// ProjectID scopes the cache to the project of the job; set from the job environment by Create
+	ProjectID string
+
+	Sccache        bool
+	SccacheVersion string
+	// SccacheChecksums are the sha256 checksums of the sccache release archives by architecture,
+	// e.g. "amd64=<sha256>,arm64=<sha256>"
+	SccacheChecksums string
+	GoProxy          string
+	AptProxy         string
+}
+
+func (o BuildCacheOptions) sccacheEnabled() bool {
+	return o.Sccache && o.S3Bucket != ""
+}
+
+// environment returns the environment variables pointing build tools to the configured caches
+func (o BuildCacheOptions) environment() map[string]string {
+	environment := map[string]string{}
+
+	// the bucket settings and credentials are kept out of the world-readable /etc/environment
+	if o.sccacheEnabled() {
+		environment["RUSTC_WRAPPER"] = sccacheWrapper
+	}
+	if o.GoProxy != "" {
+		environment["GOPROXY"] = o.GoProxy
+	}
+
+	for key, value := range environment {
+		if value == "" {
+			delete(environment, key)
+		}
+	}
+	return environment
+}
+
+// sccacheConfig returns the lines of the sccache configuration file
+func (o BuildCacheOptions) sccacheConfig() []string {
+	config := []string{"[cache.s3]"}
+	for _, setting := range [][2]string{
+		{"bucket", o.S3Bucket},
+		{"endpoint", o.S3Endpoint},
+		{"region", o.S3Region},
+		{"key_prefix", o.sccacheKeyPrefix()},
+	} {
+		if setting[1] != "" {
+			config = append(config, fmt.Sprintf("%s = %q", setting[0], setting[1]))
+		}
+	}
+	return config
+}
+
+// sccacheKeyPrefix returns the object key prefix of the project's cache; it is chosen by the runner, as the bucket
+// credentials are shared by all projects
+func (o BuildCacheOptions) sccacheKeyPrefix() string {
+	return path.Join(o.S3Prefix, "projects", o.ProjectID) + "/"
+}
+
+// sccacheCredentials returns the lines of the aws shared credentials file used by sccache
+func (o BuildCacheOptions) sccacheCredentials() []string {
+	if o.S3AccessKey == "" {
+		return nil
+	}
+	return []string{
+		"[default]",
+		"aws_access_key_id = " + o.S3AccessKey,
+		"aws_secret_access_key = " + o.S3SecretKey,
+	}
+}
+
+// sccacheChecksum returns the configured sha256 checksum of the sccache release archive for the architecture
+func (o BuildCacheOptions) sccacheChecksum(architecture string) (string, error) {
+	for _, entry := range strings.Split(o.SccacheChecksums, ",") {
+		entryArchitecture, checksum, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if entryArchitecture != architecture {
+			continue
+		}
+		if checksum = strings.ToLower(strings.TrimSpace(checksum)); !sha256Pattern.MatchString(checksum) {
+			return "", fmt.Errorf("invalid sha256 checksum of sccache for %s: %+q", architecture, checksum)
+		}
+		return checksum, nil
+	}
+	return "", fmt.Errorf("no sha256 checksum of sccache %s configured for %s", o.SccacheVersion, architecture)
+}
+
+// templateData returns the cloud-init template data for the build cache
+func (o BuildCacheOptions) templateData(architecture string) (map[string]any, error) {
+	environment := o.environment()
+	keys := make([]string, 0, len(environment))
+	for key := range environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	environmentLines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		environmentLines = append(environmentLines, fmt.Sprintf("%s=%q", key, environment[key]))
+	}
+
+	data := map[string]any{
+		"environment": environmentLines,
+		"apt_proxy":   o.AptProxy,
+	}
+	if o.sccacheEnabled() {
+		if o.ProjectID == "" {
+			return nil, errors.New("no project id to scope the sccache objects to")
+		}
+		checksum, checksumError := o.sccacheChecksum(architecture)
+		if checksumError != nil {
+			return nil, checksumError
+		}
+		data["sccache"] = map[string]any{
+			"url":         sccacheDownloadURL(o.SccacheVersion, architecture),
+			"sha256":      checksum,
+			"config":      o.sccacheConfig(),
+			"credentials": o.sccacheCredentials(),
+		}
+	}
+	return data, nil
+}
+
+// sccacheDownloadURL returns the download url of the statically linked sccache release
+func sccacheDownloadURL(version, architecture string) string {
+	target := "x86_64-unknown-linux-musl"
+	if architecture == "arm64" {
+		target = "aarch64-unknown-linux-musl"
+	}
+	return fmt.Sprintf("https://github.com/mozilla/sccache/releases/download/v%[1]s/sccache-v%[1]s-%[2]s.tar.gz", version, target)
+}
@@ -0,0 +1,91 @@
+package hetzner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildCacheTemplateData(t *testing.T) {
+	for _, testCase := range []struct {
+		name                string
+		options             BuildCacheOptions
+		expectedEnvironment []string
+		expectedSccache     any
+		expectedError       bool
+	}{
+		{
+			name:                "disabled",
+			options:             BuildCacheOptions{SccacheVersion: "0.8.2"},
+			expectedEnvironment: []string{},
+		},
+		{
+			name:                "sccache without bucket",
+			options:             BuildCacheOptions{Sccache: true, SccacheVersion: "0.8.2"},
+			expectedEnvironment: []string{},
+		},
+		{
+			name: "sccache",
+			options: BuildCacheOptions{
+				Sccache:          true,
+				SccacheVersion:   "0.8.2",
+				SccacheChecksums: "amd64=" + strings.Repeat("a", 64) + ", arm64=" + strings.Repeat("B", 64),
+				S3Bucket:         "cache",
+				S3Region:         "fsn1",
+				S3Endpoint:       "https://fsn1.your-objectstorage.com",
+				S3Prefix:         "sccache",
+				S3AccessKey:      "access",
+				S3SecretKey:      "secret",
+				ProjectID:        "42",
+			},
+			expectedEnvironment: []string{`RUSTC_WRAPPER="/usr/local/bin/hmp-sccache"`},
+			expectedSccache: map[string]any{
+				"url":    "https://github.com/mozilla/sccache/releases/download/v0.8.2/sccache-v0.8.2-aarch64-unknown-linux-musl.tar.gz",
+				"sha256": strings.Repeat("b", 64),
+				"config": []string{
+					"[cache.s3]",
+					`bucket = "cache"`,
+					`endpoint = "https://fsn1.your-objectstorage.com"`,
+					`region = "fsn1"`,
+					`key_prefix = "sccache/projects/42/"`,
+				},
+				"credentials": []string{
+					"[default]",
+					"aws_access_key_id = access",
+					"aws_secret_access_key = secret",
+				},
+			},
+		},
+		{
+			name:          "sccache without project",
+			options:       BuildCacheOptions{Sccache: true, SccacheVersion: "0.8.2", S3Bucket: "cache", SccacheChecksums: "arm64=" + strings.Repeat("a", 64)},
+			expectedError: true,
+		},
+		{
+			name:          "sccache without checksum",
+			options:       BuildCacheOptions{Sccache: true, SccacheVersion: "0.8.2", S3Bucket: "cache", SccacheChecksums: "amd64=" + strings.Repeat("a", 64), ProjectID: "42"},
+			expectedError: true,
+		},
+		{
+			name:          "sccache with invalid checksum",
+			options:       BuildCacheOptions{Sccache: true, SccacheVersion: "0.8.2", S3Bucket: "cache", SccacheChecksums: "arm64=abc", ProjectID: "42"},
+			expectedError: true,
+		},
+		{
+			name:                "go proxy",
+			options:             BuildCacheOptions{GoProxy: "https://goproxy.example.com,direct"},
+			expectedEnvironment: []string{`GOPROXY="https://goproxy.example.com,direct"`},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			data, err := testCase.options.templateData("arm64")
+			assert.Equal(t, testCase.expectedError, err != nil)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, testCase.expectedEnvironment, data["environment"])
+			assert.Equal(t, testCase.expectedSccache, data["sccache"])
+		})
+	}
+}
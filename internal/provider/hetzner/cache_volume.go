@@ -0,0 +1,161 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+const (
+	cacheVolumeLabel     = "hmp-cache"
+	cacheVolumeLockLabel = "hmp-cache-lock"
+	// cacheVolumeLockTimeLabel holds the unix time the lock was acquired at
+	cacheVolumeLockTimeLabel = "hmp-cache-lock-time"
+	cacheVolumeFormat        = "ext4"
+	// minCacheVolumeLockGracePeriod is the minimal age from which the lock of a job without server is considered stale
+	minCacheVolumeLockGracePeriod = time.Hour
+	// cacheVolumeLockCreateMargin covers the server creation, which happens after the job locked the cache volume
+	cacheVolumeLockCreateMargin = 15 * time.Minute
+)
+
+// cacheVolumeLockSettleTime is waited before verifying an acquired lock, so concurrent lock attempts become visible
+var cacheVolumeLockSettleTime = 2 * time.Second
+
+var cacheVolumeKeySanitizer = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// errCacheVolumeLocked signals that the cache volume is in use by another job
+var errCacheVolumeLocked = errors.New("cache volume is locked by another job")
+
+// cacheVolumeKey determines the cache volume key; it defaults to the project id and location
+func cacheVolumeKey(key, projectID, location string) string {
+	if key == "" {
+		key = fmt.Sprintf("project-%s-%s", projectID, location)
+	}
+	key = strings.Trim(cacheVolumeKeySanitizer.ReplaceAllString(strings.ToLower(key), "-"), "-")
+	if len(key) > 40 {
+		key = strings.Trim(key[:40], "-")
+	}
+	return key
+}
+
+// CacheVolumeLockGracePeriod returns the age from which the cache volume lock of a job without server is considered
+// stale; jobs lock the volume before their server is created and wait up to the deadline for it to become ready
+func CacheVolumeLockGracePeriod(waitDeadline time.Duration) time.Duration {
+	return max(minCacheVolumeLockGracePeriod, waitDeadline+cacheVolumeLockCreateMargin)
+}
+
+// acquireCacheVolume looks up the cache volume by its key, creates it on first use and locks it for the job. Volumes
+// attached to a server are always locked; the lock of another job is only taken over if the job has no server and
+// the lock is older than the grace period.
+func acquireCacheVolume(ctx context.Context, client *Client, jobID, key string, size int, location string, gracePeriod time.Duration) (*hcloud.Volume, error) {
+	volumes, listError := client.Volume.AllWithOpts(ctx, hcloud.VolumeListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: fmt.Sprintf("%s=%s,%s=%s", managedByLabel, managedByValue, cacheVolumeLabel, key)},
+	})
+	if listError != nil {
+		return nil, listError
+	}
+
+	if len(volumes) == 0 {
+		result, _, createError := client.Volume.Create(ctx, hcloud.VolumeCreateOpts{
+			Name:     "hmp-cache-" + key,
+			Size:     size,
+			Location: &hcloud.Location{Name: location},
+			Format:   hcloud.Ptr(cacheVolumeFormat),
+			Labels: map[string]string{
+				managedByLabel:           managedByValue,
+				cacheVolumeLabel:         key,
+				cacheVolumeLockLabel:     jobID,
+				cacheVolumeLockTimeLabel: strconv.FormatInt(time.Now().Unix(), 10),
+			},
+		})
+		if createError != nil {
+			return nil, createError
+		}
+		actions := helper.Filter(append(result.NextActions, result.Action), func(action *hcloud.Action) bool { return action != nil })
+		if waitError := client.Action.WaitFor(ctx, actions...); waitError != nil {
+			return nil, waitError
+		}
+		return result.Volume, nil
+	}
+
+	volume := volumes[0]
+	if volume.Location != nil && volume.Location.Name != location {
+		return nil, fmt.Errorf("cache volume %s is located in %s", volume.Name, volume.Location.Name)
+	}
+
+	if volume.Server != nil {
+		return nil, errCacheVolumeLocked
+	}
+
+	if lockHolder := volume.Labels[cacheVolumeLockLabel]; lockHolder != "" && lockHolder != jobID {
+		// a missing or invalid lock time is treated as stale
+		lockTime, _ := strconv.ParseInt(volume.Labels[cacheVolumeLockTimeLabel], 10, 64)
+		if time.Since(time.Unix(lockTime, 0)) < gracePeriod {
+			return nil, errCacheVolumeLocked
+		}
+		holderServers, serverListError := client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+			ListOpts: hcloud.ListOpts{LabelSelector: jobLabelSelector(lockHolder)},
+		})
+		if serverListError != nil {
+			return nil, serverListError
+		}
+		if len(holderServers) > 0 {
+			return nil, errCacheVolumeLocked
+		}
+		fmt.Printf("\t\tTake over stale cache volume lock of job %s\n", lockHolder)
+	}
+
+	labels := volume.Labels
+	labels[cacheVolumeLockLabel] = jobID
+	labels[cacheVolumeLockTimeLabel] = strconv.FormatInt(time.Now().Unix(), 10)
+	if _, _, updateError := client.Volume.Update(ctx, volume, hcloud.VolumeUpdateOpts{Labels: labels}); updateError != nil {
+		return nil, updateError
+	}
+
+	// hcloud has no compare-and-swap for labels; verify no concurrent job has overwritten the lock
+	time.Sleep(cacheVolumeLockSettleTime)
+	volume, _, getError := client.Volume.GetByID(ctx, volume.ID)
+	if getError != nil {
+		return nil, getError
+	}
+	if volume == nil || volume.Labels[cacheVolumeLockLabel] != jobID || volume.Server != nil {
+		return nil, errCacheVolumeLocked
+	}
+
+	return volume, nil
+}
+
+// releaseCacheVolumes detaches all cache volumes locked by the job and removes their lock
+func releaseCacheVolumes(ctx context.Context, client *Client, jobID string) error {
+	volumes, listError := client.Volume.AllWithOpts(ctx, hcloud.VolumeListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: fmt.Sprintf("%s=%s,%s=%s", managedByLabel, managedByValue, cacheVolumeLockLabel, jobID)},
+	})
+	if listError != nil {
+		return listError
+	}
+
+	var releaseErrors []error
+	for _, volume := range volumes {
+		fmt.Printf("\t\tRelease cache volume %s\n", volume.Name)
+		if detachError := ignoreNotFound(detachVolume(ctx, client, volume)); detachError != nil {
+			releaseErrors = append(releaseErrors, detachError)
+			continue
+		}
+
+		labels := volume.Labels
+		delete(labels, cacheVolumeLockLabel)
+		delete(labels, cacheVolumeLockTimeLabel)
+		_, _, updateError := client.Volume.Update(ctx, volume, hcloud.VolumeUpdateOpts{Labels: labels})
+		releaseErrors = append(releaseErrors, ignoreNotFound(updateError))
+	}
+
+	return errors.Join(releaseErrors...)
+}
@@ -0,0 +1,175 @@
+package hetzner
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+)
+
+func TestCacheVolumeKey(t *testing.T) {
+	for _, testCase := range []struct {
+		name      string
+		key       string
+		projectID string
+		location  string
+		expected  string
+	}{
+		{"default key", "", "42", "fsn1", "project-42-fsn1"},
+		{"custom key", "node-modules", "42", "fsn1", "node-modules"},
+		{"invalid characters", "My Cache/Key_1", "42", "fsn1", "my-cache-key-1"},
+		{"too long", "a123456789-123456789-123456789-123456789-123456789", "42", "fsn1", "a123456789-123456789-123456789-123456789"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, cacheVolumeKey(testCase.key, testCase.projectID, testCase.location))
+		})
+	}
+}
+
+// lowerCacheVolumeLockSettleTime shortens the lock verification for the test
+func lowerCacheVolumeLockSettleTime(t *testing.T) {
+	settleTime := cacheVolumeLockSettleTime
+	cacheVolumeLockSettleTime = 50 * time.Millisecond
+	t.Cleanup(func() { cacheVolumeLockSettleTime = settleTime })
+}
+
+func cacheVolumeLabels(lockHolder string, lockTime time.Time) map[string]string {
+	labels := map[string]string{managedByLabel: managedByValue, cacheVolumeLabel: "test"}
+	if lockHolder != "" {
+		labels[cacheVolumeLockLabel] = lockHolder
+	}
+	if !lockTime.IsZero() {
+		labels[cacheVolumeLockTimeLabel] = strconv.FormatInt(lockTime.Unix(), 10)
+	}
+	return labels
+}
+
+func TestAcquireCacheVolume(t *testing.T) {
+	lowerCacheVolumeLockSettleTime(t)
+	staleTime := time.Now().Add(-2 * time.Hour)
+
+	for _, testCase := range []struct {
+		name           string
+		volume         *schema.Volume
+		holderServer   bool
+		expectedError  error
+		expectedLocked bool
+	}{
+		{"create", nil, false, nil, true},
+		{"unlocked", &schema.Volume{Labels: cacheVolumeLabels("", time.Time{})}, false, nil, true},
+		{"own lock", &schema.Volume{Labels: cacheVolumeLabels("job", time.Now())}, false, nil, true},
+		{"attached", &schema.Volume{Server: hcloud.Ptr(int64(1)), Labels: cacheVolumeLabels("", time.Time{})}, false, errCacheVolumeLocked, false},
+		{"fresh lock without server", &schema.Volume{Labels: cacheVolumeLabels("other-job", time.Now())}, false, errCacheVolumeLocked, false},
+		{"stale lock with server", &schema.Volume{Labels: cacheVolumeLabels("other-job", staleTime)}, true, errCacheVolumeLocked, false},
+		{"stale lock", &schema.Volume{Labels: cacheVolumeLabels("other-job", staleTime)}, false, nil, true},
+		{"lock without time", &schema.Volume{Labels: cacheVolumeLabels("other-job", time.Time{})}, false, nil, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			api := fakehcloud.New()
+			defer api.Close()
+			client := NewClient(api.Client())
+			if testCase.volume != nil {
+				testCase.volume.Name = "hmp-cache-test"
+				testCase.volume.Location = schema.Location{Name: "fsn1"}
+				api.AddVolume(*testCase.volume)
+			}
+			if testCase.holderServer {
+				_, _, err := api.Client().Server.Create(ctx, hcloud.ServerCreateOpts{
+					Name:       "other-job",
+					ServerType: &hcloud.ServerType{Name: "cx22"},
+					Image:      &hcloud.Image{Name: "ubuntu-24.04"},
+					Location:   &hcloud.Location{Name: "fsn1"},
+					Labels:     jobLabels("other-job"),
+				})
+				assert.NoError(t, err)
+			}
+
+			volume, err := acquireCacheVolume(ctx, client, "job", "test", 10, "fsn1", time.Hour)
+			assert.ErrorIs(t, err, testCase.expectedError)
+			volumes := api.Volumes()
+			assert.Len(t, volumes, 1)
+			assert.Equal(t, testCase.expectedLocked, volumes[0].Labels[cacheVolumeLockLabel] == "job")
+			if err == nil {
+				assert.Equal(t, volumes[0].ID, volume.ID)
+				assert.NotEmpty(t, volumes[0].Labels[cacheVolumeLockTimeLabel])
+			}
+		})
+	}
+
+	t.Run("other location", func(t *testing.T) {
+		api := fakehcloud.New()
+		defer api.Close()
+		api.AddVolume(schema.Volume{Name: "hmp-cache-test", Location: schema.Location{Name: "nbg1"}, Labels: cacheVolumeLabels("", time.Time{})})
+		_, err := acquireCacheVolume(context.Background(), NewClient(api.Client()), "job", "test", 10, "fsn1", time.Hour)
+		assert.ErrorContains(t, err, "located in nbg1")
+	})
+}
+
+func TestAcquireCacheVolumeConcurrently(t *testing.T) {
+	lowerCacheVolumeLockSettleTime(t)
+	api := fakehcloud.New()
+	defer api.Close()
+	client := NewClient(api.Client())
+	api.AddVolume(schema.Volume{Name: "hmp-cache-test", Location: schema.Location{Name: "fsn1"}, Labels: cacheVolumeLabels("", time.Time{})})
+
+	const jobs = 5
+	var waitGroup sync.WaitGroup
+	results := make([]error, jobs)
+	for job := range jobs {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			_, results[job] = acquireCacheVolume(context.Background(), client, strconv.Itoa(job), "test", 10, "fsn1", time.Hour)
+		}()
+	}
+	waitGroup.Wait()
+
+	var winners []string
+	for job, err := range results {
+		if err == nil {
+			winners = append(winners, strconv.Itoa(job))
+		} else {
+			assert.ErrorIs(t, err, errCacheVolumeLocked)
+		}
+	}
+	if assert.Len(t, winners, 1) {
+		assert.Equal(t, winners[0], api.Volumes()[0].Labels[cacheVolumeLockLabel])
+	}
+}
+
+func TestReleaseCacheVolumes(t *testing.T) {
+	lowerCacheVolumeLockSettleTime(t)
+	ctx := context.Background()
+	api := fakehcloud.New()
+	defer api.Close()
+	client := NewClient(api.Client())
+	api.AddVolume(schema.Volume{Name: "hmp-cache-test", Location: schema.Location{Name: "fsn1"}, Server: hcloud.Ptr(int64(1)), Labels: cacheVolumeLabels("job", time.Now())})
+	otherVolumeID := api.AddVolume(schema.Volume{Name: "hmp-cache-other", Location: schema.Location{Name: "fsn1"}, Server: hcloud.Ptr(int64(2)), Labels: cacheVolumeLabels("other-job", time.Now())})
+
+	// the attached volume is locked until it is released
+	_, err := acquireCacheVolume(ctx, client, "next-job", "test", 10, "fsn1", time.Hour)
+	assert.ErrorIs(t, err, errCacheVolumeLocked)
+
+	assert.NoError(t, releaseCacheVolumes(ctx, client, "job"))
+	for _, volume := range api.Volumes() {
+		if volume.ID == otherVolumeID {
+			assert.NotNil(t, volume.Server)
+			assert.Equal(t, "other-job", volume.Labels[cacheVolumeLockLabel])
+			continue
+		}
+		assert.Nil(t, volume.Server)
+		assert.NotContains(t, volume.Labels, cacheVolumeLockLabel)
+		assert.NotContains(t, volume.Labels, cacheVolumeLockTimeLabel)
+	}
+
+	_, err = acquireCacheVolume(ctx, client, "next-job", "test", 10, "fsn1", time.Hour)
+	assert.NoError(t, err)
+}
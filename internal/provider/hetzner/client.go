@@ -0,0 +1,101 @@
+package hetzner
+
+import (
+	"context"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// Client is the part of the hcloud API used by hmp. Each field is satisfied by the corresponding hcloud client,
+// so tests can replace single resources or point a real hcloud client at a fake API.
+type Client struct {
+	Action         ActionAPI
+	Datacenter     DatacenterAPI
+	Firewall       FirewallAPI
+	Image          ImageAPI
+	Network        NetworkAPI
+	PlacementGroup PlacementGroupAPI
+	SSHKey         SSHKeyAPI
+	Server         ServerAPI
+	ServerType     ServerTypeAPI
+	Volume         VolumeAPI
+}
+
+// NewClient returns the Client backed by the given hcloud client
+func NewClient(client *hcloud.Client) *Client {
+	return &Client{
+		Action:         &client.Action,
+		Datacenter:     &client.Datacenter,
+		Firewall:       &client.Firewall,
+		Image:          &client.Image,
+		Network:        &client.Network,
+		PlacementGroup: &client.PlacementGroup,
+		SSHKey:         &client.SSHKey,
+		Server:         &client.Server,
+		ServerType:     &client.ServerType,
+		Volume:         &client.Volume,
+	}
+}
+
+type ActionAPI interface {
+	GetByID(ctx context.Context, id int64) (*hcloud.Action, *hcloud.Response, error)
+	WaitFor(ctx context.Context, actions ...*hcloud.Action) error
+}
+
+type DatacenterAPI interface {
+	All(ctx context.Context) ([]*hcloud.Datacenter, error)
+}
+
+type FirewallAPI interface {
+	AllWithOpts(ctx context.Context, opts hcloud.FirewallListOpts) ([]*hcloud.Firewall, error)
+	Delete(ctx context.Context, firewall *hcloud.Firewall) (*hcloud.Response, error)
+}
+
+type ImageAPI interface {
+	GetByID(ctx context.Context, id int64) (*hcloud.Image, *hcloud.Response, error)
+	List(ctx context.Context, opts hcloud.ImageListOpts) ([]*hcloud.Image, *hcloud.Response, error)
+	AllWithOpts(ctx context.Context, opts hcloud.ImageListOpts) ([]*hcloud.Image, error)
+	Delete(ctx context.Context, image *hcloud.Image) (*hcloud.Response, error)
+}
+
+type NetworkAPI interface {
+	AllWithOpts(ctx context.Context, opts hcloud.NetworkListOpts) ([]*hcloud.Network, error)
+	Delete(ctx context.Context, network *hcloud.Network) (*hcloud.Response, error)
+}
+
+type PlacementGroupAPI interface {
+	AllWithOpts(ctx context.Context, opts hcloud.PlacementGroupListOpts) ([]*hcloud.PlacementGroup, error)
+	Create(ctx context.Context, opts hcloud.PlacementGroupCreateOpts) (hcloud.PlacementGroupCreateResult, *hcloud.Response, error)
+	Delete(ctx context.Context, placementGroup *hcloud.PlacementGroup) (*hcloud.Response, error)
+}
+
+type SSHKeyAPI interface {
+	GetByName(ctx context.Context, name string) (*hcloud.SSHKey, *hcloud.Response, error)
+	AllWithOpts(ctx context.Context, opts hcloud.SSHKeyListOpts) ([]*hcloud.SSHKey, error)
+	Create(ctx context.Context, opts hcloud.SSHKeyCreateOpts) (*hcloud.SSHKey, *hcloud.Response, error)
+	Delete(ctx context.Context, sshKey *hcloud.SSHKey) (*hcloud.Response, error)
+}
+
+type ServerAPI interface {
+	GetByID(ctx context.Context, id int64) (*hcloud.Server, *hcloud.Response, error)
+	GetByName(ctx context.Context, name string) (*hcloud.Server, *hcloud.Response, error)
+	AllWithOpts(ctx context.Context, opts hcloud.ServerListOpts) ([]*hcloud.Server, error)
+	Create(ctx context.Context, opts hcloud.ServerCreateOpts) (hcloud.ServerCreateResult, *hcloud.Response, error)
+	DeleteWithResult(ctx context.Context, server *hcloud.Server) (*hcloud.ServerDeleteResult, *hcloud.Response, error)
+	RequestConsole(ctx context.Context, server *hcloud.Server) (hcloud.ServerRequestConsoleResult, *hcloud.Response, error)
+	Shutdown(ctx context.Context, server *hcloud.Server) (*hcloud.Action, *hcloud.Response, error)
+	CreateImage(ctx context.Context, server *hcloud.Server, opts *hcloud.ServerCreateImageOpts) (hcloud.ServerCreateImageResult, *hcloud.Response, error)
+}
+
+type ServerTypeAPI interface {
+	All(ctx context.Context) ([]*hcloud.ServerType, error)
+}
+
+type VolumeAPI interface {
+	GetByID(ctx context.Context, id int64) (*hcloud.Volume, *hcloud.Response, error)
+	AllWithOpts(ctx context.Context, opts hcloud.VolumeListOpts) ([]*hcloud.Volume, error)
+	Create(ctx context.Context, opts hcloud.VolumeCreateOpts) (hcloud.VolumeCreateResult, *hcloud.Response, error)
+	Delete(ctx context.Context, volume *hcloud.Volume) (*hcloud.Response, error)
+	Update(ctx context.Context, volume *hcloud.Volume, opts hcloud.VolumeUpdateOpts) (*hcloud.Volume, *hcloud.Response, error)
+	Detach(ctx context.Context, volume *hcloud.Volume) (*hcloud.Action, *hcloud.Response, error)
+}
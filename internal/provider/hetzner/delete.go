@@ -0,0 +1,109 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+// Delete deletes all resources created for the job, located by their job label; it succeeds if nothing is left
+func (p *Provider) Delete(ctx context.Context, jobID string) error {
+	client := p.client
+	listOptions := hcloud.ListOpts{LabelSelector: jobLabelSelector(jobID)}
+	var cleanupErrors []error
+
+	// servers have to be deleted first, as firewalls, volumes and networks cannot be removed while in use
+	servers, serverListError := client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{ListOpts: listOptions})
+	cleanupErrors = append(cleanupErrors, serverListError)
+	// servers created by older versions are not labeled reliably, so fall back to the name
+	if server, _, _ := client.Server.GetByName(ctx, helper.ResourceName(jobID)); server != nil && len(helper.Filter(servers, func(s *hcloud.Server) bool { return s.ID == server.ID })) == 0 {
+		servers = append(servers, server)
+	}
+	pipelineIDs := map[string]bool{}
+	for _, server := range servers {
+		if pipelineID := server.Labels[pipelineIDLabel]; pipelineID != "" {
+			pipelineIDs[pipelineID] = true
+		}
+		fmt.Printf("\t\tDelete server %s\n", server.Name)
+		cleanupErrors = append(cleanupErrors, deleteServer(ctx, client, server))
+	}
+
+	// the last job of a pipeline removes its placement groups
+	for pipelineID := range pipelineIDs {
+		cleanupErrors = append(cleanupErrors, deleteEmptyPlacementGroups(ctx, client, pipelinePlacementGroupSelector(pipelineID), 0))
+	}
+
+	cleanupErrors = append(cleanupErrors, releaseCacheVolumes(ctx, client, jobID))
+
+	sshKeys, sshKeyListError := client.SSHKey.AllWithOpts(ctx, hcloud.SSHKeyListOpts{ListOpts: listOptions})
+	cleanupErrors = append(cleanupErrors, sshKeyListError)
+	if sshKey, _, _ := client.SSHKey.GetByName(ctx, helper.ResourceName(jobID)); sshKey != nil && len(helper.Filter(sshKeys, func(k *hcloud.SSHKey) bool { return k.ID == sshKey.ID })) == 0 {
+		sshKeys = append(sshKeys, sshKey)
+	}
+	for _, sshKey := range sshKeys {
+		fmt.Printf("\t\tDelete ssh key %s\n", sshKey.Name)
+		_, err := client.SSHKey.Delete(ctx, sshKey)
+		cleanupErrors = append(cleanupErrors, ignoreNotFound(err))
+	}
+
+	firewalls, firewallListError := client.Firewall.AllWithOpts(ctx, hcloud.FirewallListOpts{ListOpts: listOptions})
+	cleanupErrors = append(cleanupErrors, firewallListError)
+	for _, firewall := range firewalls {
+		fmt.Printf("\t\tDelete firewall %s\n", firewall.Name)
+		_, err := client.Firewall.Delete(ctx, firewall)
+		cleanupErrors = append(cleanupErrors, ignoreNotFound(err))
+	}
+
+	volumes, volumeListError := client.Volume.AllWithOpts(ctx, hcloud.VolumeListOpts{ListOpts: listOptions})
+	cleanupErrors = append(cleanupErrors, volumeListError)
+	for _, volume := range volumes {
+		fmt.Printf("\t\tDelete volume %s\n", volume.Name)
+		cleanupErrors = append(cleanupErrors, ignoreNotFound(detachVolume(ctx, client, volume)))
+		_, err := client.Volume.Delete(ctx, volume)
+		cleanupErrors = append(cleanupErrors, ignoreNotFound(err))
+	}
+
+	networks, networkListError := client.Network.AllWithOpts(ctx, hcloud.NetworkListOpts{ListOpts: listOptions})
+	cleanupErrors = append(cleanupErrors, networkListError)
+	for _, network := range networks {
+		fmt.Printf("\t\tDelete network %s\n", network.Name)
+		_, err := client.Network.Delete(ctx, network)
+		cleanupErrors = append(cleanupErrors, ignoreNotFound(err))
+	}
+
+	return errors.Join(cleanupErrors...)
+}
+
+// deleteServer deletes the server and waits until it is gone, so its volumes and placement group are free
+func deleteServer(ctx context.Context, client *Client, server *hcloud.Server) error {
+	result, _, deleteError := client.Server.DeleteWithResult(ctx, server)
+	if deleteError != nil {
+		return ignoreNotFound(deleteError)
+	}
+	return ignoreNotFound(client.Action.WaitFor(ctx, result.Action))
+}
+
+// detachVolume detaches the volume from its server, if attached
+func detachVolume(ctx context.Context, client *Client, volume *hcloud.Volume) error {
+	if volume.Server == nil {
+		return nil
+	}
+
+	action, _, detachError := client.Volume.Detach(ctx, volume)
+	if detachError != nil {
+		return detachError
+	}
+	return client.Action.WaitFor(ctx, action)
+}
+
+// ignoreNotFound treats resources deleted in the meantime as successfully deleted
+func ignoreNotFound(err error) error {
+	if hcloud.IsError(err, hcloud.ErrorCodeNotFound) {
+		return nil
+	}
+	return err
+}
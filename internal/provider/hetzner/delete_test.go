@@ -0,0 +1,71 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestJobLabelSelector(t *testing.T) {
+	assert.Equal(t, "managed-by=hmp,job-id=123", jobLabelSelector("123"))
+	assert.Equal(t, map[string]string{"managed-by": "hmp", "job-id": "123"}, jobLabels("123"))
+}
+
+func TestIgnoreNotFound(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		input    error
+		expected bool
+	}{
+		{"no error", nil, false},
+		{"not found", hcloud.Error{Code: hcloud.ErrorCodeNotFound}, false},
+		{"other api error", hcloud.Error{Code: hcloud.ErrorCodeLocked}, true},
+		{"other error", errors.New("connection refused"), true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, ignoreNotFound(testCase.input) != nil)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	hcloudClient := api.Client()
+	ctx := context.Background()
+
+	image, _, err := hcloudClient.Image.GetByNameAndArchitecture(ctx, "ubuntu-24.04", hcloud.ArchitectureX86)
+	assert.NoError(t, err)
+	createServer := func(name string, labels map[string]string) {
+		_, _, createError := hcloudClient.Server.Create(ctx, hcloud.ServerCreateOpts{
+			Name:       name,
+			ServerType: &hcloud.ServerType{Name: "cx22"},
+			Image:      image,
+			Location:   &hcloud.Location{Name: "fsn1"},
+			Labels:     labels,
+		})
+		assert.NoError(t, createError)
+	}
+	createServer("labeled", jobLabels("1"))
+	createServer(helper.ResourceName("1"), nil)
+	createServer("other-job", jobLabels("2"))
+	for _, jobID := range []string{"1", "2"} {
+		_, _, createError := hcloudClient.SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{Name: helper.ResourceName(jobID), PublicKey: "ssh-ed25519 AAAA", Labels: jobLabels(jobID)})
+		assert.NoError(t, createError)
+	}
+
+	hetznerProvider := New(NewClient(hcloudClient), Options{})
+	assert.NoError(t, hetznerProvider.Delete(ctx, "1"))
+	// deleting is idempotent
+	assert.NoError(t, hetznerProvider.Delete(ctx, "1"))
+
+	assert.Equal(t, []string{"other-job"}, helper.Map(api.Servers(), func(server schema.Server) string { return server.Name }))
+	assert.Equal(t, []string{helper.ResourceName("2")}, helper.Map(api.SSHKeys(), func(sshKey schema.SSHKey) string { return sshKey.Name }))
+}
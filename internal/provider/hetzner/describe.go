@@ -0,0 +1,58 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+// Describe writes the server status and the state of its creation actions
+func (p *Provider) Describe(ctx context.Context, report io.Writer, machine *provider.Machine) {
+	serverID, _ := strconv.ParseInt(machine.ID, 10, 64)
+	server, _, getServerError := p.client.Server.GetByID(ctx, serverID)
+	switch {
+	case getServerError != nil:
+		fmt.Fprintf(report, "server: cannot get status: %s\n", getServerError)
+	case server == nil:
+		fmt.Fprintf(report, "server: not found\n")
+	default:
+		fmt.Fprintf(report, "server: %s (id=%d) status=%s created=%s\n", server.Name, server.ID, server.Status, server.Created.Format(time.RFC3339))
+	}
+
+	p.mutex.Lock()
+	createActions := p.createActions[machine.ID]
+	p.mutex.Unlock()
+	for _, createAction := range createActions {
+		if createAction == nil {
+			continue
+		}
+		action, _, getActionError := p.client.Action.GetByID(ctx, createAction.ID)
+		if getActionError != nil || action == nil {
+			fmt.Fprintf(report, "action %s: cannot get status: %v\n", createAction.Command, getActionError)
+			continue
+		}
+		fmt.Fprintf(report, "action %s: status=%s progress=%d%%", action.Command, action.Status, action.Progress)
+		if action.ErrorCode != "" {
+			fmt.Fprintf(report, " error=%s: %s", action.ErrorCode, action.ErrorMessage)
+		}
+		fmt.Fprintln(report)
+	}
+}
+
+// CaptureConsole captures the screen of the server console; the console credentials are only used for the capture
+func (p *Provider) CaptureConsole(ctx context.Context, machine *provider.Machine) (image.Image, error) {
+	serverID, _ := strconv.ParseInt(machine.ID, 10, 64)
+	consoleResult, _, consoleError := p.client.Server.RequestConsole(ctx, &hcloud.Server{ID: serverID})
+	if consoleError != nil {
+		return nil, consoleError
+	}
+	return helper.CaptureVNCScreen(ctx, consoleResult.WSSURL, consoleResult.Password)
+}
@@ -0,0 +1,47 @@
+package hetzner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultGitlabRunnerDownloadURL = "https://gitlab-runner-downloads.s3.amazonaws.com"
+	latestGitlabRunnerVersion      = "latest"
+)
+
+// GitlabRunnerOptions configure the gitlab-runner binary installed on the job server
+type GitlabRunnerOptions struct {
+	// Version defaults to the version of the runner manager invoking hmp
+	Version     string
+	DownloadURL string
+}
+
+// resolveVersion returns the gitlab-runner release to install, e.g. "v17.5.0"
+func (o GitlabRunnerOptions) resolveVersion() string {
+	version := o.Version
+	if version == "" {
+		version = os.Getenv("CUSTOM_ENV_CI_RUNNER_VERSION")
+	}
+	if version == "" || version == latestGitlabRunnerVersion {
+		return latestGitlabRunnerVersion
+	}
+	return "v" + strings.TrimPrefix(version, "v")
+}
+
+// templateData returns the cloud-init template data for downloading and verifying the gitlab-runner binary
+func (o GitlabRunnerOptions) templateData(architecture string) map[string]any {
+	downloadURL := o.DownloadURL
+	if downloadURL == "" {
+		downloadURL = DefaultGitlabRunnerDownloadURL
+	}
+	releaseURL := fmt.Sprintf("%s/%s", strings.TrimSuffix(downloadURL, "/"), o.resolveVersion())
+	checksumEntry := fmt.Sprintf("binaries/gitlab-runner-linux-%s", architecture)
+
+	return map[string]any{
+		"binary_url":     releaseURL + "/" + checksumEntry,
+		"checksum_url":   releaseURL + "/release.sha256",
+		"checksum_entry": checksumEntry,
+	}
+}
@@ -0,0 +1,47 @@
+package hetzner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitlabRunnerTemplateData(t *testing.T) {
+	for _, testCase := range []struct {
+		name                string
+		options             GitlabRunnerOptions
+		runnerVersion       string
+		expectedBinaryURL   string
+		expectedChecksumURL string
+	}{
+		{
+			name:                "latest without runner version",
+			options:             GitlabRunnerOptions{DownloadURL: DefaultGitlabRunnerDownloadURL},
+			expectedBinaryURL:   "https://gitlab-runner-downloads.s3.amazonaws.com/latest/binaries/gitlab-runner-linux-arm64",
+			expectedChecksumURL: "https://gitlab-runner-downloads.s3.amazonaws.com/latest/release.sha256",
+		},
+		{
+			name:                "version of the invoking runner",
+			options:             GitlabRunnerOptions{DownloadURL: DefaultGitlabRunnerDownloadURL},
+			runnerVersion:       "17.5.0",
+			expectedBinaryURL:   "https://gitlab-runner-downloads.s3.amazonaws.com/v17.5.0/binaries/gitlab-runner-linux-arm64",
+			expectedChecksumURL: "https://gitlab-runner-downloads.s3.amazonaws.com/v17.5.0/release.sha256",
+		},
+		{
+			name:                "pinned version and mirror",
+			options:             GitlabRunnerOptions{Version: "v16.11.1", DownloadURL: "https://mirror.example.com/gitlab-runner/"},
+			runnerVersion:       "17.5.0",
+			expectedBinaryURL:   "https://mirror.example.com/gitlab-runner/v16.11.1/binaries/gitlab-runner-linux-arm64",
+			expectedChecksumURL: "https://mirror.example.com/gitlab-runner/v16.11.1/release.sha256",
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Setenv("CUSTOM_ENV_CI_RUNNER_VERSION", testCase.runnerVersion)
+
+			data := testCase.options.templateData("arm64")
+			assert.Equal(t, testCase.expectedBinaryURL, data["binary_url"])
+			assert.Equal(t, testCase.expectedChecksumURL, data["checksum_url"])
+			assert.Equal(t, "binaries/gitlab-runner-linux-arm64", data["checksum_entry"])
+		})
+	}
+}
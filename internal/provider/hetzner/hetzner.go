@@ -0,0 +1,342 @@
+// Package hetzner provisions job machines as Hetzner Cloud servers.
+package hetzner
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/assets"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+// Name is the name the provider is selected by
+const Name = "hetzner"
+
+// Options configure the servers created by the Provider
+type Options struct {
+	AdditionalAuthorizedKeys string
+
+	CacheVolumes    bool
+	CacheVolumeKey  string
+	CacheVolumeSize int
+	CachePath       string
+	// CacheVolumeLockGracePeriod is the age from which the cache volume lock of a job without server is considered
+	// stale; defaults to CacheVolumeLockGracePeriod without wait deadline
+	CacheVolumeLockGracePeriod time.Duration
+
+	BuildCache   BuildCacheOptions
+	GitlabRunner GitlabRunnerOptions
+
+	CloudInitTemplate string
+	CloudInitExtra    string
+	// ReadinessCommands are run on the server after cloud-init has finished, separated by '\n'
+	ReadinessCommands string
+	// PlacementGroups spreads the servers of a pipeline across physical hosts
+	PlacementGroups bool
+	// MetadataCache caches server types, datacenters and system images; nil disables caching
+	MetadataCache *helper.FileCache
+	// RejectDeprecated fails jobs selecting a deprecated server type or image instead of printing a warning
+	RejectDeprecated bool
+	// ImageAliases map container style image names of jobs to image selectors, e.g. "node:20"
+	ImageAliases map[string]string
+}
+
+// Provider creates a server per job
+type Provider struct {
+	client  *Client
+	options Options
+	// sshPort is the port sshd is moved to by cloud-init; only changed by tests
+	sshPort uint16
+
+	mutex sync.Mutex
+	// createActions are the actions started by the creation of a server, by server id; used for diagnostics
+	createActions map[string][]*hcloud.Action
+}
+
+var _ provider.Provider = (*Provider)(nil)
+var _ provider.Consoler = (*Provider)(nil)
+
+// New returns a Provider using the given client
+func New(client *Client, options Options) *Provider {
+	options.CacheVolumeLockGracePeriod = cmp.Or(options.CacheVolumeLockGracePeriod, CacheVolumeLockGracePeriod(0))
+	return &Provider{client: client, options: options, sshPort: helper.CustomSSHPort, createActions: map[string][]*hcloud.Action{}}
+}
+
+// ResolveType returns the server type named by the spec, or selects one if the type is "auto"
+func (p *Provider) ResolveType(_ context.Context, spec provider.Spec) (*provider.Type, error) {
+	var serverType *hcloud.ServerType
+	var serverTypeGetError error
+	if spec.Type == "auto" {
+		serverType, serverTypeGetError = automaticServerSelection(p.client, p.options.MetadataCache, spec.Architecture, spec.Location)
+	} else {
+		serverType, serverTypeGetError = serverTypeByName(p.client, p.options.MetadataCache, spec.Type)
+	}
+	if serverTypeGetError != nil {
+		return nil, serverTypeGetError
+	}
+	if serverType.IsDeprecated() {
+		deprecationError := p.deprecated(fmt.Sprintf("server type %s is deprecated and unavailable after %s", serverType.Name, serverType.UnavailableAfter().Format(time.DateOnly)))
+		if deprecationError != nil {
+			return nil, deprecationError
+		}
+	}
+
+	return &provider.Type{
+		Name:         serverType.Name,
+		Description:  serverType.Description,
+		Architecture: determineArchitectureString(serverType.Architecture),
+	}, nil
+}
+
+// ResolveImage selects the image for the spec among the images matching the architecture of the server type; the
+// image of the spec is either an image alias or an image selector
+func (p *Provider) ResolveImage(ctx context.Context, spec provider.Spec, machineType *provider.Type) (*provider.Image, error) {
+	if aliasedSelector, isAlias := resolveImageAlias(p.options.ImageAliases, spec.Image); isAlias {
+		fmt.Printf("\t\tImage alias %s: %s\n", spec.Image, aliasedSelector)
+		spec.Image = aliasedSelector
+		return p.resolveImageSelector(ctx, spec, machineType)
+	}
+
+	image, resolveError := p.resolveImageSelector(ctx, spec, machineType)
+	if resolveError != nil && len(p.options.ImageAliases) > 0 {
+		return nil, imageAliasesError(p.options.ImageAliases, spec.Image, resolveError)
+	}
+	return image, resolveError
+}
+
+// resolveImageSelector selects the image matching the image selector of the spec
+func (p *Provider) resolveImageSelector(ctx context.Context, spec provider.Spec, machineType *provider.Type) (*provider.Image, error) {
+	architecture := hcloudArchitecture(machineType.Architecture)
+	selector, parseError := parseImageSelector(spec.Image)
+	if parseError != nil {
+		return nil, parseError
+	}
+
+	// selectors containing label selector terms match snapshots and system images; other selectors match system images
+	var images []*hcloud.Image
+	var imageListError error
+	switch {
+	case selector.id != 0:
+		image, _, imageGetError := p.client.Image.GetByID(ctx, selector.id)
+		if imageGetError != nil {
+			return nil, imageGetError
+		}
+		if image == nil {
+			return nil, fmt.Errorf("image %d not found", selector.id)
+		}
+		if image.Architecture != architecture {
+			return nil, fmt.Errorf("image %d is built for %s, the server type %s is %s", image.ID, image.Architecture, machineType.Name, architecture)
+		}
+		images = []*hcloud.Image{image}
+	case selector.labelSelector != "":
+		images, _, imageListError = p.client.Image.List(ctx, hcloud.ImageListOpts{
+			Type:              []hcloud.ImageType{hcloud.ImageTypeSnapshot, hcloud.ImageTypeSystem},
+			Status:            []hcloud.ImageStatus{hcloud.ImageStatusAvailable},
+			Architecture:      []hcloud.Architecture{architecture},
+			IncludeDeprecated: true,
+			ListOpts:          hcloud.ListOpts{LabelSelector: selector.labelSelector},
+		})
+	default:
+		images, imageListError = cachedSystemImages(p.client, p.options.MetadataCache, architecture)
+	}
+	if imageListError != nil {
+		return nil, imageListError
+	}
+
+	image, imageSelectionError := imageSelection(images, spec.Image)
+	if imageSelectionError != nil {
+		return nil, imageSelectionError
+	}
+	if image.IsDeprecated() {
+		deprecationError := p.deprecated(fmt.Sprintf("image %s is deprecated since %s and will be removed", cmp.Or(image.Name, strconv.FormatInt(image.ID, 10)), image.Deprecated.Format(time.DateOnly)))
+		if deprecationError != nil {
+			return nil, deprecationError
+		}
+	}
+
+	return &provider.Image{ID: strconv.FormatInt(image.ID, 10), Name: image.Name}, nil
+}
+
+// deprecated fails with the message if deprecated resources are rejected, otherwise it is printed as warning
+func (p *Provider) deprecated(message string) error {
+	if p.options.RejectDeprecated {
+		return errors.New(message)
+	}
+	fmt.Printf("\t\t⚠️ %s\n", message)
+	return nil
+}
+
+// Create creates the job server together with its ssh key and, if enabled, attaches a cache volume
+func (p *Provider) Create(ctx context.Context, spec provider.Spec, machineType *provider.Type, image *provider.Image) (*provider.Machine, error) {
+	buildCacheOptions := p.options.BuildCache
+	buildCacheOptions.ProjectID = os.Getenv("CUSTOM_ENV_CI_PROJECT_ID")
+	buildCache, buildCacheError := buildCacheOptions.templateData(machineType.Architecture)
+	if buildCacheError != nil {
+		return nil, fmt.Errorf("build cache: %w", buildCacheError)
+	}
+
+	var volumes []*hcloud.Volume
+	userDataBuffer := &bytes.Buffer{}
+	userData := map[string]any{
+		"ssh_authorized_keys": strings.Split(p.options.AdditionalAuthorizedKeys, "\n"),
+		"architecture":        machineType.Architecture,
+		"build_cache":         buildCache,
+		"gitlab_runner":       p.options.GitlabRunner.templateData(machineType.Architecture),
+	}
+
+	if p.options.CacheVolumes {
+		cacheKey := cacheVolumeKey(p.options.CacheVolumeKey, os.Getenv("CUSTOM_ENV_CI_PROJECT_ID"), spec.Location)
+		fmt.Printf("💾 Attach cache volume %s\n", cacheKey)
+		spec.Rollback.Add("cache volume lock", func(ctx context.Context) error {
+			return releaseCacheVolumes(ctx, p.client, spec.JobID)
+		})
+		cacheVolume, cacheVolumeError := acquireCacheVolume(ctx, p.client, spec.JobID, cacheKey, p.options.CacheVolumeSize, spec.Location, p.options.CacheVolumeLockGracePeriod)
+		if cacheVolumeError != nil {
+			fmt.Printf("\t\t⚠️ Continue without cache volume: %s\n", cacheVolumeError)
+		} else {
+			volumes = append(volumes, cacheVolume)
+			userData["cache_volume_device"] = cacheVolume.LinuxDevice
+			userData["cache_path"] = p.options.CachePath
+		}
+	}
+	cloudInitTemplate, templateLoadError := assets.LoadCloudInitTemplate(p.options.CloudInitTemplate)
+	if templateLoadError != nil {
+		return nil, templateLoadError
+	}
+	if userdataRenderError := cloudInitTemplate.Execute(userDataBuffer, userData); userdataRenderError != nil {
+		return nil, userdataRenderError
+	}
+	userDataString, userDataMergeError := helper.MergeCloudInit(userDataBuffer.String(), p.options.CloudInitExtra)
+	if userDataMergeError != nil {
+		return nil, userDataMergeError
+	}
+
+	return p.createServer(ctx, spec, machineType, image, userDataString, volumes)
+}
+
+// createServer creates a server with the given user data, injecting the public key of the spec for root
+func (p *Provider) createServer(ctx context.Context, spec provider.Spec, machineType *provider.Type, image *provider.Image, userData string, volumes []*hcloud.Volume) (*provider.Machine, error) {
+	imageID, imageIDParseError := strconv.ParseInt(image.ID, 10, 64)
+	if imageIDParseError != nil {
+		return nil, fmt.Errorf("invalid image id %+q: %w", image.ID, imageIDParseError)
+	}
+
+	// the key is injected on creation, so it is not needed afterward
+	hcloudSSHKey, _, keyCreateError := p.client.SSHKey.Create(ctx, hcloud.SSHKeyCreateOpts{
+		Name:      helper.ResourceName(spec.JobID),
+		PublicKey: string(ssh.MarshalAuthorizedKey(spec.PublicKey)),
+		Labels:    jobLabels(spec.JobID),
+	})
+	if keyCreateError != nil {
+		return nil, keyCreateError
+	}
+	defer p.client.SSHKey.Delete(context.Background(), hcloudSSHKey)
+
+	// Assign server labels from environment variables
+	labels := jobLabels(spec.JobID)
+	assignLabels(labels, map[string]string{
+		"commit-ref":    "CUSTOM_ENV_CI_COMMIT_REF_NAME",
+		"commit-sha":    "CUSTOM_ENV_CI_COMMIT_SHA",
+		pipelineIDLabel: "CUSTOM_ENV_CI_PIPELINE_ID",
+		"project-id":    "CUSTOM_ENV_CI_PROJECT_ID",
+		"tag":           "CUSTOM_ENV_CI_COMMIT_TAG",
+	})
+
+	serverCreateOpts := hcloud.ServerCreateOpts{
+		Name:       helper.ResourceName(spec.JobID),
+		ServerType: &hcloud.ServerType{Name: machineType.Name},
+		Labels:     labels,
+		SSHKeys: []*hcloud.SSHKey{
+			hcloudSSHKey,
+		},
+		Location: &hcloud.Location{
+			Name: spec.Location,
+		},
+		Image:     &hcloud.Image{ID: imageID, Name: image.Name},
+		UserData:  userData,
+		Volumes:   volumes,
+		Automount: hcloud.Ptr(false),
+	}
+	var createResult hcloud.ServerCreateResult
+	var serverCreateError error
+	pipelineID := labels[pipelineIDLabel]
+	usePlacementGroups := p.options.PlacementGroups && pipelineID != ""
+	if usePlacementGroups {
+		// a placement group might be created for the server, so the empty groups of the pipeline are removed
+		spec.Rollback.Add("placement groups of pipeline "+pipelineID, func(ctx context.Context) error {
+			return deleteEmptyPlacementGroups(ctx, p.client, pipelinePlacementGroupSelector(pipelineID), 0)
+		})
+	}
+	excludedPlacementGroups := map[int64]bool{}
+	for attempt := 1; ; attempt++ {
+		serverCreateOpts.PlacementGroup = nil
+		if usePlacementGroups {
+			placementGroup, placementGroupError := placementGroupForPipeline(ctx, p.client, pipelineID, excludedPlacementGroups)
+			if placementGroupError != nil {
+				fmt.Printf("\t\t⚠️ Continue without placement group: %s\n", placementGroupError)
+			} else {
+				fmt.Printf("\t\tPlacement group: %s\n", placementGroup.Name)
+				serverCreateOpts.PlacementGroup = placementGroup
+			}
+		}
+
+		createResult, _, serverCreateError = p.client.Server.Create(ctx, serverCreateOpts)
+		if serverCreateOpts.PlacementGroup == nil || !isPlacementGroupError(serverCreateError) || attempt == placementGroupAttempts {
+			break
+		}
+		excludedPlacementGroups[serverCreateOpts.PlacementGroup.ID] = true
+	}
+	if serverCreateError != nil {
+		fmt.Println("❌ Server creation failed")
+		return nil, serverCreateError
+	}
+
+	if createResult.Server == nil {
+		fmt.Println("❌ Server creation failed")
+		return nil, fmt.Errorf("server is not found")
+	}
+	spec.Rollback.Add("server "+createResult.Server.Name, func(ctx context.Context) error {
+		return deleteServer(ctx, p.client, createResult.Server)
+	})
+
+	machine := &provider.Machine{
+		ID:      strconv.FormatInt(createResult.Server.ID, 10),
+		Name:    createResult.Server.Name,
+		Address: createResult.Server.PublicNet.IPv4.IP.String(),
+		SSHPort: p.sshPort,
+		Created: createResult.Server.Created,
+	}
+	p.mutex.Lock()
+	p.createActions[machine.ID] = append([]*hcloud.Action{createResult.Action}, createResult.NextActions...)
+	p.mutex.Unlock()
+
+	return machine, nil
+}
+
+// Wait waits until the server is reachable, cloud-init has finished and the readiness commands succeed
+func (p *Provider) Wait(ctx context.Context, machine *provider.Machine, signer ssh.Signer) error {
+	return helper.WaitReady(ctx, signer, machine.Address, machine.SSHPort, helper.SSHRetryDelay, readinessProbes(p.options)...)
+}
+
+// readinessProbes returns the probes run after the server is reachable via ssh
+func readinessProbes(options Options) []helper.ReadinessProbe {
+	probes := []helper.ReadinessProbe{helper.CloudInitProbe{}}
+	for _, command := range strings.Split(options.ReadinessCommands, "\n") {
+		if strings.TrimSpace(command) != "" {
+			probes = append(probes, helper.CommandProbe{Command: command})
+		}
+	}
+	return probes
+}
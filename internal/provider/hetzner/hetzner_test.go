@@ -0,0 +1,71 @@
+package hetzner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+func TestReadinessProbes(t *testing.T) {
+	probes := readinessProbes(Options{ReadinessCommands: "docker info\n\n  \ntest -x /usr/local/bin/gitlab-runner"})
+
+	assert.Equal(t, []helper.ReadinessProbe{
+		helper.CloudInitProbe{},
+		helper.CommandProbe{Command: "docker info"},
+		helper.CommandProbe{Command: "test -x /usr/local/bin/gitlab-runner"},
+	}, probes)
+}
+
+func TestDeprecated(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	deprecation := schema.DeprecatableResource{Deprecation: &schema.DeprecationInfo{
+		Announced:        time.Now().Add(-24 * time.Hour),
+		UnavailableAfter: time.Now().Add(30 * 24 * time.Hour),
+	}}
+	api.AddServerType(schema.ServerType{Name: "cx11", Description: "CX11", CPUType: "shared", Architecture: "x86", DeprecatableResource: deprecation})
+	api.AddDatacenter("hel1-dc2", "hel1", "cx11", "cx22")
+	api.AddImage(schema.Image{Name: hcloud.Ptr("ubuntu-20.04"), Type: "system", OSFlavor: "ubuntu", OSVersion: hcloud.Ptr("20.04"), Architecture: "x86", Deprecated: hcloud.Ptr(time.Now().Add(-time.Hour))})
+	api.AddImage(schema.Image{Name: hcloud.Ptr("ubuntu-26.04"), Type: "system", OSFlavor: "ubuntu", OSVersion: hcloud.Ptr("26.04"), Architecture: "x86", Deprecated: hcloud.Ptr(time.Now().Add(-time.Hour))})
+
+	for _, testCase := range []struct {
+		name             string
+		rejectDeprecated bool
+		spec             provider.Spec
+		expectedType     string
+		expectedImage    string
+		expectedError    bool
+	}{
+		{"auto skips deprecated types", true, provider.Spec{Type: "auto", Architecture: "amd64", Location: "hel1", Image: "ubuntu-24.04"}, "cx22", "ubuntu-24.04", false},
+		{"latest skips deprecated images", true, provider.Spec{Type: "cx22", Image: "ubuntu:latest"}, "cx22", "ubuntu-24.04", false},
+		{"deprecated type warns", false, provider.Spec{Type: "cx11", Image: "ubuntu-24.04"}, "cx11", "ubuntu-24.04", false},
+		{"deprecated image warns", false, provider.Spec{Type: "cx22", Image: "ubuntu-20.04"}, "cx22", "ubuntu-20.04", false},
+		{"deprecated type rejected", true, provider.Spec{Type: "cx11", Image: "ubuntu-24.04"}, "", "", true},
+		{"deprecated image rejected", true, provider.Spec{Type: "cx22", Image: "ubuntu-20.04"}, "cx22", "", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			hetznerProvider := New(NewClient(api.Client()), Options{RejectDeprecated: testCase.rejectDeprecated})
+			machineType, err := hetznerProvider.ResolveType(context.Background(), testCase.spec)
+			if err != nil {
+				assert.True(t, testCase.expectedError)
+				assert.Empty(t, testCase.expectedType)
+				return
+			}
+			assert.Equal(t, testCase.expectedType, machineType.Name)
+
+			image, err := hetznerProvider.ResolveImage(context.Background(), testCase.spec, machineType)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			if err == nil {
+				assert.Equal(t, testCase.expectedImage, image.Name)
+			}
+		})
+	}
+}
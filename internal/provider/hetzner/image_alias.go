@@ -0,0 +1,60 @@
+package hetzner
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ReadImageAliases reads image aliases from a file with one "<alias> <image selector>" pair per line, e.g.
+// "node:20 label#hmp-image=node,version=20"; lines starting with '#' are ignored
+func ReadImageAliases(path string) (map[string]string, error) {
+	fh, openError := os.Open(path)
+	if openError != nil {
+		return nil, openError
+	}
+	defer fh.Close()
+
+	aliases := map[string]string{}
+	scanner := bufio.NewScanner(fh)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		alias, selector := line, ""
+		if separator := strings.IndexAny(line, " \t"); separator >= 0 {
+			alias, selector = line[:separator], strings.TrimSpace(line[separator:])
+		}
+		if selector == "" {
+			return nil, fmt.Errorf("%s:%d: no image selector given for alias %+q", path, lineNumber, alias)
+		}
+		if _, parseError := parseImageSelector(selector); parseError != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, parseError)
+		}
+		aliases[alias] = selector
+	}
+	return aliases, scanner.Err()
+}
+
+// resolveImageAlias returns the image selector the image of a job is an alias for. Like with docker, an image
+// without tag refers to the "latest" tag.
+func resolveImageAlias(aliases map[string]string, image string) (string, bool) {
+	if selector, found := aliases[image]; found {
+		return selector, true
+	}
+	if !strings.Contains(image, ":") {
+		selector, found := aliases[image+latestImageSuffix]
+		return selector, found
+	}
+	return "", false
+}
+
+// imageAliasesError extends the error of an image which is neither an alias nor a valid image selector
+func imageAliasesError(aliases map[string]string, image string, err error) error {
+	return fmt.Errorf("image %+q is not an alias and cannot be resolved: %w; available aliases: %s",
+		image, err, strings.Join(slices.Sorted(maps.Keys(aliases)), ", "))
+}
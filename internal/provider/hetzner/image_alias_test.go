@@ -0,0 +1,88 @@
+package hetzner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+func TestReadImageAliases(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		content       string
+		expected      map[string]string
+		expectedError bool
+	}{
+		{"empty", "# no aliases yet\n", map[string]string{}, false},
+		{"aliases", "# snapshots\nnode:20   label#hmp-image=node,version=20\n\nubuntu:22.04\tubuntu-22.04\n", map[string]string{
+			"node:20":      "label#hmp-image=node,version=20",
+			"ubuntu:22.04": "ubuntu-22.04",
+		}, false},
+		{"missing selector", "node:20\n", nil, true},
+		{"invalid selector", "debian debian-[\n", nil, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "aliases")
+			assert.NoError(t, os.WriteFile(path, []byte(testCase.content), 0o600))
+			aliases, err := ReadImageAliases(path)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			assert.Equal(t, testCase.expected, aliases)
+		})
+	}
+}
+
+func TestResolveImageAlias(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	snapshotID := api.AddImage(schema.Image{Type: "snapshot", Description: "node 20", Architecture: "x86", Labels: map[string]string{"hmp-image": "node", "version": "20"}})
+	aliases := map[string]string{
+		"node:20":       "label#hmp-image=node,version=20",
+		"ubuntu:22.04":  "ubuntu-22.04",
+		"ubuntu:latest": "ubuntu:latest",
+		"debian:12":     "debian-12",
+	}
+	machineType := &provider.Type{Name: "cx22", Architecture: "amd64"}
+
+	for _, testCase := range []struct {
+		name          string
+		aliases       map[string]string
+		image         string
+		expectedID    string
+		expectedName  string
+		expectedError bool
+	}{
+		{"snapshot", aliases, "node:20", strconv.FormatInt(snapshotID, 10), "", false},
+		{"system image", aliases, "ubuntu:22.04", "", "ubuntu-22.04", false},
+		{"without tag", aliases, "ubuntu", "", "ubuntu-24.04", false},
+		{"image selector", aliases, "ubuntu-24.04", "", "ubuntu-24.04", false},
+		{"unmapped", aliases, "node:18", "", "", true},
+		{"alias of a missing image", aliases, "debian:12", "", "", true},
+		{"no aliases", nil, "ubuntu:22.04", "", "", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			hetznerProvider := New(NewClient(api.Client()), Options{ImageAliases: testCase.aliases})
+			image, err := hetznerProvider.ResolveImage(context.Background(), provider.Spec{Image: testCase.image}, machineType)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			if err != nil {
+				return
+			}
+			if testCase.expectedID != "" {
+				assert.Equal(t, testCase.expectedID, image.ID)
+			}
+			assert.Equal(t, testCase.expectedName, image.Name)
+		})
+	}
+
+	// unmapped images list the available aliases
+	hetznerProvider := New(NewClient(api.Client()), Options{ImageAliases: aliases})
+	_, err := hetznerProvider.ResolveImage(context.Background(), provider.Spec{Image: "node:18"}, machineType)
+	assert.ErrorContains(t, err, "available aliases: debian:12, node:20, ubuntu:22.04, ubuntu:latest")
+}
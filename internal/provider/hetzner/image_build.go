@@ -0,0 +1,232 @@
+package hetzner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+const (
+	imageNameLabel    = "hmp-image"
+	imageVersionLabel = "version"
+	// imageBuildUserData is the user data of build servers; unlike the job cloud-init, it keeps sshd on port 22 and
+	// installs nothing, so the snapshot only contains what the provisioning script adds
+	imageBuildUserData = "#cloud-config\n"
+	// imageSealCommands prepare the build server for the snapshot: servers created from it run cloud-init like on
+	// their first boot and do not authorize the ssh key of the build
+	imageSealCommands = "cloud-init clean --logs\nrm -f \"$HOME/.ssh/authorized_keys\"\nsync\n"
+)
+
+var (
+	// imageBuildSSHPort is the ssh port of build servers; only changed by tests
+	imageBuildSSHPort uint16 = 22
+	// imageBuildShutdownTimeout is the time the build server has to shut down before the snapshot
+	imageBuildShutdownTimeout = 5 * time.Minute
+	// serverStatusPollInterval is the delay between checks whether a server has changed its status
+	serverStatusPollInterval = 2 * time.Second
+)
+
+// ImageBuildOptions configure the build of a snapshot
+type ImageBuildOptions struct {
+	// Name is the value of the hmp-image label
+	Name string
+	// Version is the value of the version label; defaults to the highest numeric version of the image plus one
+	Version string
+
+	BaseImage    string
+	ServerType   string
+	Architecture string
+	Location     string
+	// Script is the path of the provisioning script, run as root on the build server
+	Script       string
+	WaitDeadline time.Duration
+}
+
+// BuildImage boots a server from the base image, provisions it using the script, shuts it down and creates a snapshot
+// labeled with the image name and version; the build server is removed afterward
+func BuildImage(hetznerProvider *Provider, options ImageBuildOptions) (buildError error) {
+	ctx := context.Background()
+	client := hetznerProvider.client
+
+	script, readScriptError := os.ReadFile(options.Script)
+	if readScriptError != nil {
+		return readScriptError
+	}
+
+	version := options.Version
+	if version == "" {
+		var versionError error
+		if version, versionError = nextImageVersion(ctx, client, options.Name); versionError != nil {
+			return versionError
+		}
+	}
+	labels := map[string]string{imageNameLabel: options.Name, imageVersionLabel: version}
+	if labelsValid, labelValidationError := hcloud.ValidateResourceLabels(map[string]any{imageNameLabel: options.Name, imageVersionLabel: version}); !labelsValid {
+		return fmt.Errorf("invalid image name or version: %w", labelValidationError)
+	}
+	fmt.Printf("📀 Build image %s version %s\n", options.Name, version)
+
+	// the build server is handled like a job, so it is located by the job label on cleanup
+	jobID, jobIDError := buildJobID()
+	if jobIDError != nil {
+		return jobIDError
+	}
+	defer func() {
+		fmt.Println("🧹 Remove build server")
+		buildError = errors.Join(buildError, hetznerProvider.Delete(context.Background(), jobID))
+	}()
+
+	privateKey, _, generateSSHKeyError := helper.GenerateSSHKeyPair(helper.SSHKeyTypeED25519)
+	if generateSSHKeyError != nil {
+		return generateSSHKeyError
+	}
+	signer, pkParseError := ssh.ParsePrivateKey([]byte(privateKey))
+	if pkParseError != nil {
+		return pkParseError
+	}
+
+	spec := provider.Spec{
+		JobID:        jobID,
+		Image:        options.BaseImage,
+		Type:         options.ServerType,
+		Architecture: options.Architecture,
+		Location:     options.Location,
+		PublicKey:    signer.PublicKey(),
+	}
+	machineType, resolveTypeError := hetznerProvider.ResolveType(ctx, spec)
+	if resolveTypeError != nil {
+		return resolveTypeError
+	}
+	baseImage, resolveImageError := hetznerProvider.ResolveImage(ctx, spec, machineType)
+	if resolveImageError != nil {
+		return resolveImageError
+	}
+	fmt.Printf("📠 Create build server\n\t\tType:  %+v [%s]\n\t\tImage: %+v\n", machineType.Description, machineType.Architecture, baseImage.DisplayName())
+
+	machine, createError := hetznerProvider.createServer(ctx, spec, machineType, baseImage, imageBuildUserData, nil)
+	if createError != nil {
+		return createError
+	}
+	machine.SSHPort = imageBuildSSHPort
+	fmt.Printf("⏳ Waiting %s for server to be ready\n", options.WaitDeadline)
+	waitDeadlineContext, cancel := context.WithTimeout(ctx, options.WaitDeadline)
+	defer cancel()
+	if waitReadyError := helper.WaitReady(waitDeadlineContext, signer, machine.Address, machine.SSHPort, helper.SSHRetryDelay, helper.CloudInitProbe{}); waitReadyError != nil {
+		return waitReadyError
+	}
+
+	fmt.Println("🔧 Run provisioning script")
+	sshClient, sshClientError := helper.NewSSHClient(signer, machine.Address, machine.SSHPort)
+	if sshClientError != nil {
+		return sshClientError
+	}
+	provisionError := sshClient.RunCommand(ctx, string(script))
+	var sealError error
+	if provisionError == nil {
+		fmt.Println("🧽 Reset cloud-init and remove the build ssh key")
+		sealError = sshClient.RunCommand(ctx, imageSealCommands)
+	}
+	sshClient.Close()
+	if provisionError != nil {
+		return fmt.Errorf("provisioning script failed: %w", provisionError)
+	}
+	if sealError != nil {
+		return fmt.Errorf("cannot prepare the server for the snapshot: %w", sealError)
+	}
+
+	serverID, _ := strconv.ParseInt(machine.ID, 10, 64)
+	server := &hcloud.Server{ID: serverID}
+	fmt.Println("🔌 Shut down build server")
+	if shutdownError := shutdownServer(ctx, client, server); shutdownError != nil {
+		return shutdownError
+	}
+
+	fmt.Println("📸 Create snapshot")
+	createImageResult, _, createImageError := client.Server.CreateImage(ctx, server, &hcloud.ServerCreateImageOpts{
+		Type:        hcloud.ImageTypeSnapshot,
+		Description: hcloud.Ptr(fmt.Sprintf("%s %s", options.Name, version)),
+		Labels:      labels,
+	})
+	if createImageError != nil {
+		return createImageError
+	}
+	if waitError := client.Action.WaitFor(ctx, createImageResult.Action); waitError != nil {
+		return waitError
+	}
+
+	fmt.Printf("✅ Snapshot %d created, select it using the image %s%s=%s,%s=%s\n",
+		createImageResult.Image.ID, labelSelectorPrefix, imageNameLabel, options.Name, imageVersionLabel, version)
+	return nil
+}
+
+// shutdownServer shuts the server down gracefully, so its file systems are clean, and waits until it is off
+func shutdownServer(ctx context.Context, client *Client, server *hcloud.Server) error {
+	shutdownContext, cancel := context.WithTimeout(ctx, imageBuildShutdownTimeout)
+	defer cancel()
+
+	shutdownAction, _, shutdownError := client.Server.Shutdown(shutdownContext, server)
+	if shutdownError != nil {
+		return shutdownError
+	}
+	if waitError := client.Action.WaitFor(shutdownContext, shutdownAction); waitError != nil {
+		return waitError
+	}
+	// the action only sends the shutdown request to the os
+	for {
+		currentServer, _, getError := client.Server.GetByID(shutdownContext, server.ID)
+		if getError != nil {
+			return getError
+		}
+		if currentServer == nil {
+			return fmt.Errorf("server %d not found", server.ID)
+		}
+		if currentServer.Status == hcloud.ServerStatusOff {
+			return nil
+		}
+		select {
+		case <-shutdownContext.Done():
+			return fmt.Errorf("server did not shut down: %w", shutdownContext.Err())
+		case <-time.After(serverStatusPollInterval):
+		}
+	}
+}
+
+// buildJobID returns the job id of a build server; the random suffix keeps builds started in the same second apart
+func buildJobID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, randomError := rand.Read(suffix); randomError != nil {
+		return "", randomError
+	}
+	return fmt.Sprintf("image-%d-%s", time.Now().Unix(), hex.EncodeToString(suffix)), nil
+}
+
+// nextImageVersion returns the highest numeric version of the image's snapshots plus one
+func nextImageVersion(ctx context.Context, client *Client, name string) (string, error) {
+	images, imageListError := client.Image.AllWithOpts(ctx, hcloud.ImageListOpts{
+		Type:              []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+		IncludeDeprecated: true,
+		ListOpts:          hcloud.ListOpts{LabelSelector: fmt.Sprintf("%s=%s", imageNameLabel, name)},
+	})
+	if imageListError != nil {
+		return "", imageListError
+	}
+
+	latestVersion := 0
+	for _, image := range images {
+		if version, parseError := strconv.Atoi(image.Labels[imageVersionLabel]); parseError == nil {
+			latestVersion = max(latestVersion, version)
+		}
+	}
+	return strconv.Itoa(latestVersion + 1), nil
+}
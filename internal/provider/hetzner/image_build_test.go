@@ -0,0 +1,120 @@
+package hetzner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakessh"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestBuildImage(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	// the build server authorizes the ssh keys injected on creation
+	var buildServerName atomic.Value
+	server, err := fakessh.Start(fakessh.Options{
+		Authorize: func(key ssh.PublicKey) bool {
+			for _, hcloudServer := range api.Servers() {
+				for _, publicKey := range api.AuthorizedKeys(hcloudServer.Name) {
+					authorizedKey, _, _, _, parseError := ssh.ParseAuthorizedKey([]byte(publicKey))
+					if parseError == nil && bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+						buildServerName.Store(hcloudServer.Name)
+						return true
+					}
+				}
+			}
+			return false
+		},
+	})
+	assert.NoError(t, err)
+	defer server.Close()
+	api.ServerIP = server.Host()
+	buildSSHPort := imageBuildSSHPort
+	imageBuildSSHPort = server.Port()
+	t.Cleanup(func() { imageBuildSSHPort = buildSSHPort })
+	hetznerProvider := New(NewClient(api.Client()), Options{})
+	authorizedKeysPath := filepath.Join(server.Dir, ".ssh", "authorized_keys")
+
+	for _, testCase := range []struct {
+		name            string
+		version         string
+		script          string
+		expectedVersion string
+		expectedError   bool
+	}{
+		{"first version", "", "echo node > provisioned", "1", false},
+		{"next version", "", "echo node > provisioned", "2", false},
+		{"explicit version", "20", "echo node > provisioned", "20", false},
+		{"invalid version", "20 beta", "echo node > provisioned", "", true},
+		{"script fails", "", "exit 1", "", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			scriptPath := filepath.Join(t.TempDir(), "provision.sh")
+			assert.NoError(t, os.WriteFile(scriptPath, []byte(testCase.script), 0o600))
+			snapshotCount := len(api.Images())
+			assert.NoError(t, os.MkdirAll(filepath.Dir(authorizedKeysPath), 0o700))
+			assert.NoError(t, os.WriteFile(authorizedKeysPath, []byte("ssh-ed25519 AAAA build\n"), 0o600))
+
+			err := BuildImage(hetznerProvider, ImageBuildOptions{
+				Name:         "node",
+				Version:      testCase.version,
+				BaseImage:    "ubuntu-24.04",
+				ServerType:   "auto",
+				Architecture: "amd64",
+				Location:     "fsn1",
+				Script:       scriptPath,
+				WaitDeadline: 5 * time.Second,
+			})
+			assert.Equal(t, testCase.expectedError, err != nil)
+			// the build server is removed in any case
+			assert.Empty(t, api.Servers())
+			assert.Empty(t, api.SSHKeys())
+
+			images := api.Images()
+			if testCase.expectedError {
+				assert.Len(t, images, snapshotCount)
+				return
+			}
+			assert.Len(t, images, snapshotCount+1)
+			// the job cloud-init is not part of the snapshot, and servers created from it neither authorize the build
+			// key nor skip cloud-init
+			assert.Equal(t, imageBuildUserData, api.UserData(buildServerName.Load().(string)))
+			assert.Contains(t, server.Commands(), imageSealCommands)
+			assert.NoFileExists(t, authorizedKeysPath)
+			snapshot := images[len(images)-1]
+			assert.Equal(t, string(hcloud.ImageTypeSnapshot), snapshot.Type)
+			assert.Equal(t, map[string]string{"hmp-image": "node", "version": testCase.expectedVersion}, snapshot.Labels)
+
+			// the snapshot is picked up by the label image selector
+			image, err := imageSelection([]*hcloud.Image{hcloud.ImageFromSchema(snapshot)}, "label#hmp-image=node,version="+testCase.expectedVersion)
+			assert.NoError(t, err)
+			assert.Equal(t, snapshot.ID, image.ID)
+		})
+	}
+	provisioned, err := os.ReadFile(filepath.Join(server.Dir, "provisioned"))
+	assert.NoError(t, err)
+	assert.Equal(t, "node\n", string(provisioned))
+}
+
+func TestBuildJobID(t *testing.T) {
+	first, err := buildJobID()
+	assert.NoError(t, err)
+	second, err := buildJobID()
+	assert.NoError(t, err)
+	// builds started in the same second get distinct job ids
+	assert.NotEqual(t, first, second)
+
+	labelsValid, _ := hcloud.ValidateResourceLabels(map[string]any{jobIDLabel: first})
+	assert.True(t, labelsValid)
+	assert.LessOrEqual(t, len(helper.ResourceName(first)), 50)
+}
@@ -0,0 +1,89 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// ImagePruneOptions configure which snapshots are deleted
+type ImagePruneOptions struct {
+	// Selector is the label selector of the snapshots, with or without the "label#" prefix
+	Selector string
+	// Keep is the number of latest snapshots jobs can select, which are kept regardless of their age
+	Keep int
+	// MaxAge is the age from which the remaining snapshots are deleted; 0 deletes them regardless of their age
+	MaxAge time.Duration
+	// DryRun only prints the snapshots which would be deleted
+	DryRun bool
+}
+
+// PruneImages deletes the snapshots matching the selector, except for the latest ones, the ones younger than the
+// max age and the ones servers managed by hmp have been created from
+func PruneImages(client *Client, options ImagePruneOptions) error {
+	ctx := context.Background()
+	selector := strings.TrimPrefix(options.Selector, labelSelectorPrefix)
+	// an empty selector would match all snapshots of the project
+	if selector == "" {
+		return errors.New("no image selector given")
+	}
+
+	images, imageListError := client.Image.AllWithOpts(ctx, hcloud.ImageListOpts{
+		Type:              []hcloud.ImageType{hcloud.ImageTypeSnapshot},
+		IncludeDeprecated: true,
+		ListOpts:          hcloud.ListOpts{LabelSelector: selector},
+	})
+	if imageListError != nil {
+		return imageListError
+	}
+	// same order as the label image selector, so the kept snapshots include the one selected by jobs
+	sortLatestFirst(images)
+
+	servers, serverListError := client.Server.AllWithOpts(ctx, hcloud.ServerListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: fmt.Sprintf("%s=%s", managedByLabel, managedByValue)},
+	})
+	if serverListError != nil {
+		return serverListError
+	}
+	usedBy := map[int64]string{}
+	for _, server := range servers {
+		if server.Image != nil {
+			usedBy[server.Image.ID] = server.Name
+		}
+	}
+
+	if options.DryRun {
+		fmt.Printf("🔍 Dry run, snapshots matching %s are not deleted\n", selector)
+	} else {
+		fmt.Printf("🗑️ Prune snapshots matching %s\n", selector)
+	}
+	var deleteErrors []error
+	selectable := 0
+	for _, image := range images {
+		snapshot := fmt.Sprintf("%d %+q created %s", image.ID, image.Description, image.Created.Format(time.DateTime))
+		// like the label image selector, only available snapshots which are not deprecated count as the latest
+		isSelectable := image.Status == hcloud.ImageStatusAvailable && !image.IsDeprecated()
+		if isSelectable {
+			selectable++
+		}
+		switch {
+		case isSelectable && selectable <= options.Keep:
+			fmt.Printf("\t\tKeep %s: latest %d\n", snapshot, options.Keep)
+		case options.MaxAge > 0 && time.Since(image.Created) < options.MaxAge:
+			fmt.Printf("\t\tKeep %s: younger than %s\n", snapshot, options.MaxAge)
+		case usedBy[image.ID] != "":
+			fmt.Printf("\t\tKeep %s: used by server %s\n", snapshot, usedBy[image.ID])
+		case options.DryRun:
+			fmt.Printf("\t\tWould delete %s\n", snapshot)
+		default:
+			fmt.Printf("\t\tDelete %s\n", snapshot)
+			_, deleteError := client.Image.Delete(ctx, image)
+			deleteErrors = append(deleteErrors, ignoreNotFound(deleteError))
+		}
+	}
+	return errors.Join(deleteErrors...)
+}
@@ -0,0 +1,106 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestPruneImages(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		options       ImagePruneOptions
+		expected      []string
+		expectedError bool
+	}{
+		{"dry run", ImagePruneOptions{Selector: "hmp-image=foo", Keep: 0, DryRun: true}, []string{"foo 1", "foo 2", "foo 3", "foo 4", "foo 5"}, false},
+		{"keep and max age", ImagePruneOptions{Selector: "hmp-image=foo", Keep: 3, MaxAge: 30 * 24 * time.Hour}, []string{"foo 2", "foo 3", "foo 4", "foo 5"}, false},
+		{"keep", ImagePruneOptions{Selector: "label#hmp-image=foo", Keep: 1}, []string{"foo 2", "foo 5"}, false},
+		{"max age", ImagePruneOptions{Selector: "hmp-image=foo", MaxAge: 45 * 24 * time.Hour}, []string{"foo 2", "foo 3", "foo 4", "foo 5"}, false},
+		{"no selector", ImagePruneOptions{Keep: 1}, []string{"foo 1", "foo 2", "foo 3", "foo 4", "foo 5"}, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			api := fakehcloud.New()
+			defer api.Close()
+			snapshotIDs := map[string]int64{}
+			for version, age := range []int{60, 50, 40, 20, 1} {
+				description := fmt.Sprintf("foo %d", version+1)
+				snapshotIDs[description] = api.AddImage(schema.Image{
+					Type:         "snapshot",
+					Description:  description,
+					Created:      hcloud.Ptr(time.Now().Add(-time.Duration(age) * 24 * time.Hour)),
+					Architecture: "x86",
+					Labels:       map[string]string{"hmp-image": "foo"},
+				})
+			}
+			api.AddImage(schema.Image{Type: "snapshot", Description: "bar 1", Created: hcloud.Ptr(time.Now().Add(-90 * 24 * time.Hour)), Architecture: "x86", Labels: map[string]string{"hmp-image": "bar"}})
+
+			// a job server protects its snapshot, other servers do not
+			hcloudClient := api.Client()
+			for name, snapshot := range map[string]string{helper.ResourceName("1"): "foo 2", "unmanaged": "foo 1"} {
+				labels := jobLabels("1")
+				if name == "unmanaged" {
+					labels = nil
+				}
+				_, _, createError := hcloudClient.Server.Create(context.Background(), hcloud.ServerCreateOpts{
+					Name:       name,
+					ServerType: &hcloud.ServerType{Name: "cx22"},
+					Image:      &hcloud.Image{ID: snapshotIDs[snapshot]},
+					Location:   &hcloud.Location{Name: "fsn1"},
+					Labels:     labels,
+				})
+				assert.NoError(t, createError)
+			}
+
+			err := PruneImages(NewClient(hcloudClient), testCase.options)
+			assert.Equal(t, testCase.expectedError, err != nil)
+
+			snapshots := helper.Filter(api.Images(), func(image schema.Image) bool { return image.Type == "snapshot" })
+			descriptions := helper.Map(snapshots, func(image schema.Image) string { return image.Description })
+			assert.Contains(t, descriptions, "bar 1")
+			assert.Equal(t, testCase.expected, helper.Filter(descriptions, func(description string) bool { return strings.HasPrefix(description, "foo") }))
+		})
+	}
+}
+
+func TestPruneImagesDeprecated(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	for _, snapshot := range []struct {
+		description string
+		age         int
+		deprecated  bool
+	}{
+		{"foo 1", 20, false},
+		{"foo 2", 10, false},
+		{"foo 3", 5, true},
+	} {
+		image := schema.Image{
+			Type:         "snapshot",
+			Description:  snapshot.description,
+			Created:      hcloud.Ptr(time.Now().Add(-time.Duration(snapshot.age) * 24 * time.Hour)),
+			Architecture: "x86",
+			Labels:       map[string]string{"hmp-image": "foo"},
+		}
+		if snapshot.deprecated {
+			image.Deprecated = hcloud.Ptr(time.Now())
+		}
+		api.AddImage(image)
+	}
+
+	// the latest snapshot jobs can select is kept, although a deprecated one is newer
+	assert.NoError(t, PruneImages(NewClient(api.Client()), ImagePruneOptions{Selector: "hmp-image=foo", Keep: 1}))
+	descriptions := helper.Map(api.Images(), func(image schema.Image) string { return image.Description })
+	assert.Contains(t, descriptions, "foo 2")
+	assert.NotContains(t, descriptions, "foo 1")
+	assert.NotContains(t, descriptions, "foo 3")
+}
@@ -0,0 +1,218 @@
+package hetzner
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+const imageIDPrefix = "@id:"
+
+// imageFilterPattern matches a filter on an image attribute; other terms of a selector are label selector terms
+var imageFilterPattern = regexp.MustCompile(`^\s*(name|description|os_flavor|os_version)\s*(!=|>=|<=|=|>|<)\s*(.*?)\s*$`)
+
+// imageFilter is a condition on an attribute of an image
+type imageFilter struct {
+	attribute string
+	operator  string
+	value     string
+}
+
+// matches reports whether the image fulfills the filter. The os_version is compared as version, other attributes
+// are matched against the value as glob pattern.
+func (f imageFilter) matches(image *hcloud.Image) bool {
+	if f.attribute == "os_version" {
+		comparison := compareVersions(image.OSVersion, f.value)
+		switch f.operator {
+		case "=":
+			return comparison == 0
+		case "!=":
+			return comparison != 0
+		case ">":
+			return comparison > 0
+		case ">=":
+			return comparison >= 0
+		case "<":
+			return comparison < 0
+		default:
+			return comparison <= 0
+		}
+	}
+
+	attributes := map[string]string{"name": image.Name, "description": image.Description, "os_flavor": image.OSFlavor}
+	matched, _ := path.Match(f.value, attributes[f.attribute])
+	return matched == (f.operator == "=")
+}
+
+// imageSelector selects an image; the grammar is:
+//
+//	@id:<id>                 the image with the id
+//	label#<label selector>   the images matching the label selector, which is passed to the api unchanged
+//	<terms>                  filters and label selector terms separated by ',', e.g. "os_flavor=ubuntu,os_version>=22.04"
+//	<pattern>:latest         the images containing the pattern in their name
+//	<pattern>                the images with a name matching the glob pattern, e.g. "debian-*"
+//
+// Images matched by label selector terms are ordered by their creation date, others by their os version, the latest
+// first. Deprecated images are skipped, unless the selector names a single image by its id or name.
+type imageSelector struct {
+	id                int64
+	labelSelector     string
+	filters           []imageFilter
+	includeDeprecated bool
+}
+
+// parseImageSelector parses the image selector of a job
+func parseImageSelector(selector string) (*imageSelector, error) {
+	switch {
+	case strings.HasPrefix(selector, imageIDPrefix):
+		id, idParseError := strconv.ParseInt(strings.TrimPrefix(selector, imageIDPrefix), 10, 64)
+		if idParseError != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid image id in selector %+q", selector)
+		}
+		return &imageSelector{id: id, includeDeprecated: true}, nil
+	case isLabelSelector(selector):
+		// terms like "name=foo" are label selector terms here, as they have been before filters were introduced
+		labelSelector := strings.TrimPrefix(selector, labelSelectorPrefix)
+		if strings.TrimSpace(labelSelector) == "" {
+			return nil, fmt.Errorf("empty label selector %+q", selector)
+		}
+		return &imageSelector{labelSelector: labelSelector}, nil
+	case strings.ContainsAny(selector, "=<>"):
+		return parseImageSelectorTerms(selector)
+	case strings.HasSuffix(selector, latestImageSuffix):
+		return newNameImageSelector("*" + strings.TrimSuffix(selector, latestImageSuffix) + "*")
+	default:
+		return newNameImageSelector(selector)
+	}
+}
+
+// newNameImageSelector returns a selector for the images with a name matching the pattern
+func newNameImageSelector(pattern string) (*imageSelector, error) {
+	if _, patternError := path.Match(pattern, ""); patternError != nil {
+		return nil, fmt.Errorf("invalid name pattern %+q: %w", pattern, patternError)
+	}
+	return &imageSelector{
+		filters:           []imageFilter{{attribute: "name", operator: "=", value: pattern}},
+		includeDeprecated: !strings.ContainsAny(pattern, `*?[\`),
+	}, nil
+}
+
+// parseImageSelectorTerms parses filters and label selector terms separated by ','
+func parseImageSelectorTerms(selector string) (*imageSelector, error) {
+	parsedSelector := &imageSelector{}
+	var labelTerms []string
+	for _, term := range splitSelectorTerms(selector) {
+		match := imageFilterPattern.FindStringSubmatch(term)
+		if match == nil {
+			labelTerms = append(labelTerms, strings.TrimSpace(term))
+			continue
+		}
+
+		filter := imageFilter{attribute: match[1], operator: match[2], value: match[3]}
+		if filter.attribute != "os_version" {
+			if filter.operator != "=" && filter.operator != "!=" {
+				return nil, fmt.Errorf("operator %s is not supported for %s", filter.operator, filter.attribute)
+			}
+			if _, patternError := path.Match(filter.value, ""); patternError != nil {
+				return nil, fmt.Errorf("invalid %s pattern %+q: %w", filter.attribute, filter.value, patternError)
+			}
+		}
+		parsedSelector.filters = append(parsedSelector.filters, filter)
+	}
+	parsedSelector.labelSelector = strings.Join(labelTerms, ",")
+
+	if parsedSelector.labelSelector == "" && len(parsedSelector.filters) == 0 {
+		return nil, fmt.Errorf("empty image selector %+q", selector)
+	}
+	return parsedSelector, nil
+}
+
+// splitSelectorTerms splits a selector at ',', except within parentheses like in "env in (dev,test)"
+func splitSelectorTerms(selector string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for index, character := range selector {
+		switch character {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:index])
+				start = index + 1
+			}
+		}
+	}
+	terms = append(terms, selector[start:])
+	return helper.Filter(terms, func(term string) bool { return strings.TrimSpace(term) != "" })
+}
+
+// filter returns the images matching the selector, ordered by preference
+func (s *imageSelector) filter(images []*hcloud.Image) []*hcloud.Image {
+	filteredImages := helper.Filter(images, s.matches)
+	if s.labelSelector != "" {
+		sortLatestFirst(filteredImages)
+	} else {
+		sort.SliceStable(filteredImages, func(i, j int) bool {
+			return compareVersions(filteredImages[i].OSVersion, filteredImages[j].OSVersion) > 0
+		})
+	}
+	return filteredImages
+}
+
+// matches reports whether the image fulfills the id and all filters of the selector; label selector terms are
+// applied by the api
+func (s *imageSelector) matches(image *hcloud.Image) bool {
+	if s.id != 0 && image.ID != s.id || !s.includeDeprecated && image.IsDeprecated() {
+		return false
+	}
+	for _, filter := range s.filters {
+		if !filter.matches(image) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersions compares os versions like "9", "22.04" or "24.10" component-wise. Numeric components are
+// compared by value, others lexically; non-numeric components, like "unknown", sort below numeric ones.
+// Missing components count as zero.
+func compareVersions(a, b string) int {
+	componentsA, componentsB := strings.Split(a, "."), strings.Split(b, ".")
+	for index := range max(len(componentsA), len(componentsB)) {
+		componentA, componentB := "0", "0"
+		if index < len(componentsA) {
+			componentA = componentsA[index]
+		}
+		if index < len(componentsB) {
+			componentB = componentsB[index]
+		}
+
+		numberA, parseErrorA := strconv.ParseUint(componentA, 10, 64)
+		numberB, parseErrorB := strconv.ParseUint(componentB, 10, 64)
+		var comparison int
+		switch {
+		case parseErrorA == nil && parseErrorB == nil:
+			comparison = cmp.Compare(numberA, numberB)
+		case parseErrorA == nil:
+			comparison = 1
+		case parseErrorB == nil:
+			comparison = -1
+		default:
+			comparison = strings.Compare(componentA, componentB)
+		}
+		if comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
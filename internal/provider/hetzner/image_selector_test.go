@@ -0,0 +1,148 @@
+package hetzner
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, testCase := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"22.04", "22.04", 0},
+		{"9", "22.04", -1},
+		{"24.04", "22.10", 1},
+		{"22.4", "22.04", 0},
+		{"12", "12.0", 0},
+		{"24.10", "24.04", 1},
+		{"unknown", "9", -1},
+		{"", "9", -1},
+		{"stream", "unknown", -1},
+	} {
+		t.Run(testCase.a+" "+testCase.b, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, compareVersions(testCase.a, testCase.b))
+			assert.Equal(t, -testCase.expected, compareVersions(testCase.b, testCase.a))
+		})
+	}
+}
+
+func TestParseImageSelector(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		selector      string
+		expected      *imageSelector
+		expectedError bool
+	}{
+		{"name", "ubuntu-24.04", &imageSelector{filters: []imageFilter{{"name", "=", "ubuntu-24.04"}}, includeDeprecated: true}, false},
+		{"glob", "debian-*", &imageSelector{filters: []imageFilter{{"name", "=", "debian-*"}}}, false},
+		{"latest", "ubuntu:latest", &imageSelector{filters: []imageFilter{{"name", "=", "*ubuntu*"}}}, false},
+		{"id", "@id:12345", &imageSelector{id: 12345, includeDeprecated: true}, false},
+		{"label", "label#hmp-image=node,version=20", &imageSelector{labelSelector: "hmp-image=node,version=20"}, false},
+		{"label existence", "label#hmp-image", &imageSelector{labelSelector: "hmp-image"}, false},
+		{"label set", "label#env in (dev,test)", &imageSelector{labelSelector: "env in (dev,test)"}, false},
+		{"label named like a filter", "label#name=foo,os_flavor=bar", &imageSelector{labelSelector: "name=foo,os_flavor=bar"}, false},
+		{"filters", "os_flavor=ubuntu, os_version >= 22.04", &imageSelector{filters: []imageFilter{{"os_flavor", "=", "ubuntu"}, {"os_version", ">=", "22.04"}}}, false},
+		{"combined", "hmp-image=node,description!=*beta*", &imageSelector{labelSelector: "hmp-image=node", filters: []imageFilter{{"description", "!=", "*beta*"}}}, false},
+		{"invalid id", "@id:latest", nil, true},
+		{"invalid glob", "debian-[", nil, true},
+		{"invalid filter glob", "name=debian-[", nil, true},
+		{"unsupported operator", "name>=debian", nil, true},
+		{"empty", "label#", nil, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			selector, err := parseImageSelector(testCase.selector)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			assert.Equal(t, testCase.expected, selector)
+		})
+	}
+}
+
+func TestImageSelectorFilter(t *testing.T) {
+	images := []*hcloud.Image{
+		{ID: 1, Name: "ubuntu-22.04", OSFlavor: "ubuntu", OSVersion: "22.04"},
+		{ID: 2, Name: "ubuntu-24.04", OSFlavor: "ubuntu", OSVersion: "24.04"},
+		{ID: 3, Name: "debian-11", OSFlavor: "debian", OSVersion: "11"},
+		{ID: 4, Name: "debian-12", OSFlavor: "debian", OSVersion: "12"},
+		{ID: 5, Name: "rocky-9", OSFlavor: "rocky", OSVersion: "9"},
+		{ID: 6, Name: "rocky-10", OSFlavor: "rocky", OSVersion: "10"},
+		{ID: 7, Name: "ubuntu-20.04", OSFlavor: "ubuntu", OSVersion: "20.04", Deprecated: time.Now().Add(-time.Hour)},
+		{ID: 8, Name: "ubuntu-26.04", OSFlavor: "ubuntu", OSVersion: "26.04", Deprecated: time.Now().Add(-time.Hour)},
+	}
+
+	for _, testCase := range []struct {
+		name     string
+		selector string
+		expected []int64
+	}{
+		{"exact name", "debian-11", []int64{3}},
+		{"deprecated exact name", "ubuntu-20.04", []int64{7}},
+		{"latest skips deprecated", "ubuntu:latest", []int64{2, 1}},
+		{"deprecated id", "@id:8", []int64{8}},
+		{"glob ordered by version", "debian-*", []int64{4, 3}},
+		{"latest compares versions", "rocky:latest", []int64{6, 5}},
+		{"flavor", "os_flavor=ubuntu", []int64{2, 1}},
+		{"version range", "os_version>=11,os_version<23", []int64{1, 4, 3}},
+		{"exclusion", "os_flavor!=ubuntu,name!=rocky-*", []int64{4, 3}},
+		{"id", "@id:5", []int64{5}},
+		{"no match", "fedora-*", nil},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			selector, err := parseImageSelector(testCase.selector)
+			assert.NoError(t, err)
+			var ids []int64
+			for _, image := range selector.filter(images) {
+				ids = append(ids, image.ID)
+			}
+			assert.Equal(t, testCase.expected, ids)
+		})
+	}
+}
+
+func TestResolveImage(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	snapshotID := api.AddImage(schema.Image{Type: "snapshot", Description: "node 1", OSFlavor: "ubuntu", OSVersion: hcloud.Ptr("24.04"), Architecture: "x86", Labels: map[string]string{"hmp-image": "node"}})
+	betaSnapshotID := api.AddImage(schema.Image{Type: "snapshot", Description: "node 2 beta", OSFlavor: "ubuntu", OSVersion: hcloud.Ptr("24.04"), Architecture: "x86", Labels: map[string]string{"hmp-image": "node"}, Created: hcloud.Ptr(time.Now().Add(time.Minute))})
+	nameLabelSnapshotID := api.AddImage(schema.Image{Type: "snapshot", Description: "foo", Architecture: "x86", Labels: map[string]string{"name": "foo"}})
+	armSnapshotID := api.AddImage(schema.Image{Type: "snapshot", Description: "node 1", Architecture: "arm", Labels: map[string]string{"hmp-image": "node"}})
+	hetznerProvider := New(NewClient(api.Client()), Options{})
+	machineType := &provider.Type{Name: "cx22", Architecture: "amd64"}
+
+	for _, testCase := range []struct {
+		name          string
+		selector      string
+		expectedID    int64
+		expectedName  string
+		expectedError bool
+	}{
+		{"system image", "ubuntu:latest", 0, "ubuntu-24.04", false},
+		{"latest snapshot", "label#hmp-image=node", betaSnapshotID, "", false},
+		{"combined", "hmp-image=node,description!=*beta*", snapshotID, "", false},
+		{"label named like a filter", "label#name=foo", nameLabelSnapshotID, "", false},
+		{"pinned", "@id:" + strconv.FormatInt(snapshotID, 10), snapshotID, "", false},
+		{"pinned other architecture", "@id:" + strconv.FormatInt(armSnapshotID, 10), 0, "", true},
+		{"pinned missing", "@id:999999", 0, "", true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			image, err := hetznerProvider.ResolveImage(context.Background(), provider.Spec{Image: testCase.selector}, machineType)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			if err != nil {
+				return
+			}
+			if testCase.expectedID != 0 {
+				assert.Equal(t, strconv.FormatInt(testCase.expectedID, 10), image.ID)
+			}
+			assert.Equal(t, testCase.expectedName, image.Name)
+		})
+	}
+}
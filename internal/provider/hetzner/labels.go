@@ -0,0 +1,45 @@
+package hetzner
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	managedByLabel = "managed-by"
+	managedByValue = "hmp"
+	jobIDLabel     = "job-id"
+)
+
+// jobLabels returns the labels every resource created for a job is tagged with
+func jobLabels(jobID string) map[string]string {
+	return map[string]string{
+		managedByLabel: managedByValue,
+		jobIDLabel:     jobID,
+	}
+}
+
+// jobLabelSelector returns the label selector matching all resources created for a job
+func jobLabelSelector(jobID string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", managedByLabel, managedByValue, jobIDLabel, jobID)
+}
+
+// assignLabels assigns values from environment variables to server labels
+func assignLabels(labels map[string]string, labelEnvironmentVariableMapping map[string]string) {
+	for label, environmentVariable := range labelEnvironmentVariableMapping {
+		if value, variableIsSet := os.LookupEnv(environmentVariable); variableIsSet {
+			labelValid, labelValidationError := hcloud.ValidateResourceLabels(map[string]any{label: value})
+			if labelValidationError != nil {
+				fmt.Printf("\t\t⚠️ Label validation failed: %+q\n", labelValidationError)
+				continue
+			}
+			if !labelValid {
+				continue
+			}
+
+			labels[label] = value
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+// cache keys of the metadata which rarely changes; entries are stored in the api schema representation
+const (
+	serverTypesCacheKey  = "server-types"
+	datacentersCacheKey  = "datacenters"
+	systemImagesCacheKey = "system-images"
+)
+
+// datacentersCacheTTL limits the time datacenters are cached regardless of the metadata cache ttl, as they carry the
+// server type availability, which changes with the stock of a location
+const datacentersCacheTTL = 5 * time.Minute
+
+// cachedServerTypes returns all server types
+func cachedServerTypes(client *Client, cache *helper.FileCache) ([]*hcloud.ServerType, error) {
+	serverTypes, fetchError := helper.Cached(cache, serverTypesCacheKey, func() ([]schema.ServerType, error) {
+		serverTypes, fetchError := client.ServerType.All(context.Background())
+		return helper.Map(serverTypes, hcloud.SchemaFromServerType), fetchError
+	})
+	return helper.Map(serverTypes, hcloud.ServerTypeFromSchema), fetchError
+}
+
+// cachedDatacenters returns all datacenters
+func cachedDatacenters(client *Client, cache *helper.FileCache) ([]*hcloud.Datacenter, error) {
+	datacenters, fetchError := helper.Cached(cache.WithMaxTTL(datacentersCacheTTL), datacentersCacheKey, func() ([]schema.Datacenter, error) {
+		datacenters, fetchError := client.Datacenter.All(context.Background())
+		return helper.Map(datacenters, hcloud.SchemaFromDatacenter), fetchError
+	})
+	return helper.Map(datacenters, hcloud.DatacenterFromSchema), fetchError
+}
+
+// cachedSystemImages returns all available system images of the given architecture; snapshots are not cached,
+// as they change with every image build
+func cachedSystemImages(client *Client, cache *helper.FileCache, architecture hcloud.Architecture) ([]*hcloud.Image, error) {
+	images, fetchError := helper.Cached(cache, systemImagesCacheKey+"-"+string(architecture), func() ([]schema.Image, error) {
+		images, fetchError := client.Image.AllWithOpts(context.Background(), hcloud.ImageListOpts{
+			Type:              []hcloud.ImageType{hcloud.ImageTypeSystem},
+			Status:            []hcloud.ImageStatus{hcloud.ImageStatusAvailable},
+			Architecture:      []hcloud.Architecture{architecture},
+			IncludeDeprecated: true,
+		})
+		return helper.Map(images, hcloud.SchemaFromImage), fetchError
+	})
+	return helper.Map(images, hcloud.ImageFromSchema), fetchError
+}
+
+// CacheRefresh fetches the cached metadata again
+func CacheRefresh(client *Client, cache *helper.FileCache) error {
+	if clearError := cache.Clear(); clearError != nil {
+		return clearError
+	}
+
+	serverTypes, fetchError := cachedServerTypes(client, cache)
+	if fetchError != nil {
+		return fetchError
+	}
+	fmt.Printf("🗂️ Cached %d server types\n", len(serverTypes))
+
+	datacenters, fetchError := cachedDatacenters(client, cache)
+	if fetchError != nil {
+		return fetchError
+	}
+	fmt.Printf("🗂️ Cached %d datacenters\n", len(datacenters))
+
+	for _, architecture := range []hcloud.Architecture{hcloud.ArchitectureX86, hcloud.ArchitectureARM} {
+		images, fetchError := cachedSystemImages(client, cache, architecture)
+		if fetchError != nil {
+			return fetchError
+		}
+		fmt.Printf("🗂️ Cached %d system images [%s]\n", len(images), determineArchitectureString(architecture))
+	}
+
+	return nil
+}
+
+// CacheClear removes the cached metadata
+func CacheClear(cache *helper.FileCache) error {
+	if clearError := cache.Clear(); clearError != nil {
+		return clearError
+	}
+	fmt.Println("🧹 Cache cleared")
+	return nil
+}
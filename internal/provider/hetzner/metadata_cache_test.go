@@ -0,0 +1,72 @@
+package hetzner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+func TestCachedServerTypes(t *testing.T) {
+	cache := &helper.FileCache{Dir: t.TempDir(), TTL: time.Hour}
+	serverTypes := []*hcloud.ServerType{
+		{ID: 1, Name: "cx22", Architecture: hcloud.ArchitectureX86, CPUType: hcloud.CPUTypeShared, Cores: 2},
+		{ID: 2, Name: "cax11", Architecture: hcloud.ArchitectureARM, CPUType: hcloud.CPUTypeShared, Cores: 2},
+	}
+	_, err := helper.Cached(cache, serverTypesCacheKey, func() ([]schema.ServerType, error) {
+		return helper.Map(serverTypes, hcloud.SchemaFromServerType), nil
+	})
+	assert.NoError(t, err)
+
+	// served from the cache, so no client is needed
+	cached, err := cachedServerTypes(nil, cache)
+	assert.NoError(t, err)
+	assert.Len(t, cached, 2)
+	for i, serverType := range cached {
+		assert.Equal(t, serverTypes[i].ID, serverType.ID)
+		assert.Equal(t, serverTypes[i].Name, serverType.Name)
+		assert.Equal(t, serverTypes[i].Architecture, serverType.Architecture)
+		assert.Equal(t, serverTypes[i].CPUType, serverType.CPUType)
+	}
+
+	serverType, err := serverTypeByName(nil, cache, "cax11")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), serverType.ID)
+
+	_, err = serverTypeByName(nil, cache, "cpx11")
+	assert.Error(t, err)
+}
+
+func TestCachedDatacenters(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	client := NewClient(api.Client())
+	cache := &helper.FileCache{Dir: t.TempDir(), TTL: 24 * time.Hour}
+
+	// entries which would still be valid for the metadata cache ttl
+	created := time.Now().Add(-2 * datacentersCacheTTL)
+	for key, data := range map[string]string{
+		serverTypesCacheKey: `[{"id": 1, "name": "cx22"}]`,
+		datacentersCacheKey: `[{"id": 1, "name": "sold-out", "server_types": {"available": []}}]`,
+	} {
+		entry := fmt.Sprintf(`{"created": %q, "data": %s}`, created.Format(time.RFC3339Nano), data)
+		assert.NoError(t, os.WriteFile(filepath.Join(cache.Dir, key+".json"), []byte(entry), 0o600))
+	}
+
+	serverTypes, err := cachedServerTypes(client, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cx22"}, helper.Map(serverTypes, func(serverType *hcloud.ServerType) string { return serverType.Name }))
+
+	// the availability is fetched again after a few minutes
+	datacenters, err := cachedDatacenters(client, cache)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fsn1-dc14", "nbg1-dc3", "ash-dc1"}, helper.Map(datacenters, func(datacenter *hcloud.Datacenter) string { return datacenter.Name }))
+}
@@ -0,0 +1,117 @@
+package hetzner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+const (
+	pipelineIDLabel          = "pipeline-id"
+	placementGroupShardLabel = "hmp-placement-shard"
+	// placementGroupMaxServers is the number of servers a spread placement group can contain
+	placementGroupMaxServers = 10
+	// placementGroupAttempts is the number of placement groups tried for a server, as groups might be filled up or
+	// removed by concurrent jobs
+	placementGroupAttempts = 3
+)
+
+// placementGroupGCMinAge protects placement groups from gc, which have been created for a server not created yet
+var placementGroupGCMinAge = 10 * time.Minute
+
+// pipelinePlacementGroupSelector returns the label selector matching the placement groups of a pipeline
+func pipelinePlacementGroupSelector(pipelineID string) string {
+	return fmt.Sprintf("%s=%s,%s=%s,%s", managedByLabel, managedByValue, pipelineIDLabel, pipelineID, placementGroupShardLabel)
+}
+
+// placementGroupForPipeline returns a spread placement group of the pipeline with room for another server. The
+// pipeline's servers are sharded across groups, as each group is limited to placementGroupMaxServers; a new shard is
+// created once all groups are full. Groups in exclude are treated as full.
+func placementGroupForPipeline(ctx context.Context, client *Client, pipelineID string, exclude map[int64]bool) (*hcloud.PlacementGroup, error) {
+	for attempt := 1; ; attempt++ {
+		placementGroups, listError := client.PlacementGroup.AllWithOpts(ctx, hcloud.PlacementGroupListOpts{
+			ListOpts: hcloud.ListOpts{LabelSelector: pipelinePlacementGroupSelector(pipelineID)},
+			Type:     hcloud.PlacementGroupTypeSpread,
+		})
+		if listError != nil {
+			return nil, listError
+		}
+		sort.SliceStable(placementGroups, func(i, j int) bool {
+			return placementGroupShard(placementGroups[i]) < placementGroupShard(placementGroups[j])
+		})
+
+		usedShards := map[int]bool{}
+		for _, placementGroup := range placementGroups {
+			usedShards[placementGroupShard(placementGroup)] = true
+			if !exclude[placementGroup.ID] && len(placementGroup.Servers) < placementGroupMaxServers {
+				return placementGroup, nil
+			}
+		}
+
+		shard := 0
+		for usedShards[shard] {
+			shard++
+		}
+		createResult, _, createError := client.PlacementGroup.Create(ctx, hcloud.PlacementGroupCreateOpts{
+			Name: fmt.Sprintf("hmp-pipeline-%s-%d", pipelineID, shard),
+			Labels: map[string]string{
+				managedByLabel:           managedByValue,
+				pipelineIDLabel:          pipelineID,
+				placementGroupShardLabel: strconv.Itoa(shard),
+			},
+			Type: hcloud.PlacementGroupTypeSpread,
+		})
+		// a concurrent job has created the shard in the meantime, so it is picked up by listing again
+		if hcloud.IsError(createError, hcloud.ErrorCodeUniquenessError) && attempt < placementGroupAttempts {
+			continue
+		}
+		if createError != nil {
+			return nil, createError
+		}
+		return createResult.PlacementGroup, nil
+	}
+}
+
+// placementGroupShard returns the shard number of a placement group
+func placementGroupShard(placementGroup *hcloud.PlacementGroup) int {
+	shard, _ := strconv.Atoi(placementGroup.Labels[placementGroupShardLabel])
+	return shard
+}
+
+// isPlacementGroupError reports whether the server could not be created due to its placement group, which might
+// have been filled up or removed by a concurrent job
+func isPlacementGroupError(err error) bool {
+	return hcloud.IsError(err, hcloud.ErrorCodePlacementError) || hcloud.IsError(err, hcloud.ErrorCodeNotFound)
+}
+
+// deleteEmptyPlacementGroups deletes the placement groups matching the selector which contain no servers and are
+// older than minAge
+func deleteEmptyPlacementGroups(ctx context.Context, client *Client, selector string, minAge time.Duration) error {
+	placementGroups, listError := client.PlacementGroup.AllWithOpts(ctx, hcloud.PlacementGroupListOpts{
+		ListOpts: hcloud.ListOpts{LabelSelector: selector},
+	})
+	if listError != nil {
+		return listError
+	}
+
+	var deleteErrors []error
+	for _, placementGroup := range placementGroups {
+		if len(placementGroup.Servers) > 0 || time.Since(placementGroup.Created) < minAge {
+			continue
+		}
+		fmt.Printf("\t\tDelete placement group %s\n", placementGroup.Name)
+		_, deleteError := client.PlacementGroup.Delete(ctx, placementGroup)
+		deleteErrors = append(deleteErrors, ignoreNotFound(deleteError))
+	}
+	return errors.Join(deleteErrors...)
+}
+
+// GC deletes resources which are not bound to a single job and have been left behind, like empty placement groups
+func GC(client *Client) error {
+	return deleteEmptyPlacementGroups(context.Background(), client, fmt.Sprintf("%s=%s,%s", managedByLabel, managedByValue, placementGroupShardLabel), placementGroupGCMinAge)
+}
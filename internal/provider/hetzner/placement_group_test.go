@@ -0,0 +1,110 @@
+package hetzner
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud/schema"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakehcloud"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+// placementGroupServers returns the number of servers by placement group name
+func placementGroupServers(api *fakehcloud.Server) map[string]int {
+	servers := map[string]int{}
+	for _, placementGroup := range api.PlacementGroups() {
+		servers[placementGroup.Name] = len(placementGroup.Servers)
+	}
+	return servers
+}
+
+func TestPlacementGroups(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	t.Setenv("CUSTOM_ENV_CI_PIPELINE_ID", "42")
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	assert.NoError(t, err)
+
+	hetznerProvider := New(NewClient(api.Client()), Options{PlacementGroups: true})
+	ctx := context.Background()
+	for job := 1; job <= 11; job++ {
+		spec := provider.Spec{JobID: fmt.Sprint(job), Image: "ubuntu-24.04", Type: "cx22", Location: "fsn1", PublicKey: sshPublicKey}
+		machineType, resolveTypeError := hetznerProvider.ResolveType(ctx, spec)
+		assert.NoError(t, resolveTypeError)
+		image, resolveImageError := hetznerProvider.ResolveImage(ctx, spec, machineType)
+		assert.NoError(t, resolveImageError)
+		_, createError := hetznerProvider.Create(ctx, spec, machineType, image)
+		assert.NoError(t, createError)
+	}
+	// a placement group holds at most 10 servers
+	assert.Equal(t, map[string]int{"hmp-pipeline-42-0": 10, "hmp-pipeline-42-1": 1}, placementGroupServers(api))
+
+	assert.NoError(t, hetznerProvider.Delete(ctx, "11"))
+	assert.Equal(t, map[string]int{"hmp-pipeline-42-0": 10}, placementGroupServers(api))
+	// the first shard has room again
+	assert.NoError(t, hetznerProvider.Delete(ctx, "1"))
+	placementGroup, err := placementGroupForPipeline(ctx, hetznerProvider.client, "42", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "hmp-pipeline-42-0", placementGroup.Name)
+
+	// the last cleanup of the pipeline removes its placement groups
+	for job := 2; job <= 10; job++ {
+		assert.NoError(t, hetznerProvider.Delete(ctx, fmt.Sprint(job)))
+	}
+	assert.Empty(t, api.PlacementGroups())
+	assert.Empty(t, api.Servers())
+}
+
+func TestPlacementGroupForPipeline(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	client := NewClient(api.Client())
+	ctx := context.Background()
+
+	first, err := placementGroupForPipeline(ctx, client, "1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, hcloud.PlacementGroupTypeSpread, first.Type)
+	again, err := placementGroupForPipeline(ctx, client, "1", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	// a group which turned out to be unusable is skipped
+	next, err := placementGroupForPipeline(ctx, client, "1", map[int64]bool{first.ID: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "hmp-pipeline-1-1", next.Name)
+
+	other, err := placementGroupForPipeline(ctx, client, "2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "hmp-pipeline-2-0", other.Name)
+}
+
+func TestGC(t *testing.T) {
+	api := fakehcloud.New()
+	defer api.Close()
+	client := NewClient(api.Client())
+	ctx := context.Background()
+
+	_, err := placementGroupForPipeline(ctx, client, "1", nil)
+	assert.NoError(t, err)
+	_, _, err = api.Client().PlacementGroup.Create(ctx, hcloud.PlacementGroupCreateOpts{Name: "unmanaged", Type: hcloud.PlacementGroupTypeSpread})
+	assert.NoError(t, err)
+
+	// recently created groups might be about to receive a server
+	assert.NoError(t, GC(client))
+	assert.Len(t, api.PlacementGroups(), 2)
+
+	minAge := placementGroupGCMinAge
+	placementGroupGCMinAge = 0
+	t.Cleanup(func() { placementGroupGCMinAge = minAge })
+	assert.NoError(t, GC(client))
+	assert.Equal(t, []string{"unmanaged"}, helper.Map(api.PlacementGroups(), func(placementGroup schema.PlacementGroup) string { return placementGroup.Name }))
+}
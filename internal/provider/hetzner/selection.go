@@ -0,0 +1,141 @@
+package hetzner
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+const labelSelectorPrefix = "label#"
+const latestImageSuffix = ":latest"
+
+func determineArchitectureString(serverArchitecture hcloud.Architecture) string {
+	switch serverArchitecture {
+	case hcloud.ArchitectureX86:
+		return "amd64"
+	case hcloud.ArchitectureARM:
+		return "arm64"
+	}
+
+	return "amd64"
+}
+
+// hcloudArchitecture is the inverse of determineArchitectureString
+func hcloudArchitecture(architecture string) hcloud.Architecture {
+	if architecture == "arm64" {
+		return hcloud.ArchitectureARM
+	}
+	return hcloud.ArchitectureX86
+}
+
+// getServerClassification determines server classification by server type name to be sortable (e.g. "cx11" -> "11")
+func getServerClassification(server string) string {
+	return regexp.MustCompile(`\d+`).FindString(server)
+}
+
+// getAvailableServerTypesByLocation determines datacenters in provided location and get available server types
+func getAvailableServerTypesByLocation(client *Client, cache *helper.FileCache, locationName string) ([]*hcloud.ServerType, error) {
+	datacenters, fetchDatacentersError := cachedDatacenters(client, cache)
+	if fetchDatacentersError != nil {
+		return nil, fetchDatacentersError
+	}
+	datacenters = helper.Filter(datacenters, func(datacenter *hcloud.Datacenter) bool {
+		return datacenter.Location.Name == locationName
+	})
+	if len(datacenters) == 0 || datacenters == nil {
+		return nil, fmt.Errorf("no datacenters found for location %s", locationName)
+	}
+
+	datacenter := datacenters[0]
+	if len(datacenter.ServerTypes.Available) == 0 {
+		return nil, fmt.Errorf("no server types available in %s", locationName)
+	}
+
+	// fetch all server types at once instead of one request per available server type
+	allServerTypes, serverTypeListError := cachedServerTypes(client, cache)
+	if serverTypeListError != nil {
+		return nil, serverTypeListError
+	}
+	available := make(map[int64]bool, len(datacenter.ServerTypes.Available))
+	for _, availableServerType := range datacenter.ServerTypes.Available {
+		available[availableServerType.ID] = true
+	}
+
+	return helper.Filter(allServerTypes, func(serverType *hcloud.ServerType) bool {
+		return available[serverType.ID]
+	}), nil
+}
+
+// imageSelection selects an image based on the image selector
+func imageSelection(images []*hcloud.Image, imageSelector string) (*hcloud.Image, error) {
+	selector, parseError := parseImageSelector(imageSelector)
+	if parseError != nil {
+		return nil, parseError
+	}
+
+	filteredImages := selector.filter(images)
+	if len(filteredImages) == 0 {
+		return nil, fmt.Errorf("no images found for selector %+q", imageSelector)
+	}
+
+	return &hcloud.Image{
+		ID:         filteredImages[0].ID,
+		Name:       filteredImages[0].Name,
+		Deprecated: filteredImages[0].Deprecated,
+	}, nil
+}
+
+// sortLatestFirst sorts images by their creation date, the latest first
+func sortLatestFirst(images []*hcloud.Image) {
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+}
+
+// isLabelSelector checks if the image selector is a label selector
+func isLabelSelector(imageSelector string) bool {
+	return strings.HasPrefix(imageSelector, labelSelectorPrefix)
+}
+
+// automaticServerSelection selects a server type based on the architecture and CPU type
+func automaticServerSelection(client *Client, cache *helper.FileCache, architecture string, location string) (*hcloud.ServerType, error) {
+	serverTypes, serverTypeListError := getAvailableServerTypesByLocation(client, cache, location)
+	if serverTypeListError != nil {
+		return nil, serverTypeListError
+	}
+	// filter server types by architecture and CPU type, skipping deprecated ones
+	possibleServerTypes := helper.Filter(serverTypes, func(serverType *hcloud.ServerType) bool {
+		return determineArchitectureString(serverType.Architecture) == architecture && serverType.CPUType == hcloud.CPUTypeShared && !serverType.IsDeprecated()
+	})
+
+	if len(possibleServerTypes) == 0 {
+		return nil, fmt.Errorf("no server type found for architecture %+q", architecture)
+	}
+
+	// sort server types by classification (e.g. cx11, cx21, cx31, ...)
+	sort.SliceStable(possibleServerTypes, func(i, j int) bool {
+		return getServerClassification(possibleServerTypes[i].Name) > getServerClassification(possibleServerTypes[j].Name)
+	})
+	// get the server located in the middle of the list of possible server types
+	return possibleServerTypes[len(possibleServerTypes)/2], nil
+}
+
+// serverTypeByName returns the server type with the given name
+func serverTypeByName(client *Client, cache *helper.FileCache, name string) (*hcloud.ServerType, error) {
+	serverTypes, serverTypeListError := cachedServerTypes(client, cache)
+	if serverTypeListError != nil {
+		return nil, serverTypeListError
+	}
+	serverTypes = helper.Filter(serverTypes, func(serverType *hcloud.ServerType) bool {
+		return serverType.Name == name
+	})
+	if len(serverTypes) == 0 {
+		return nil, fmt.Errorf("server type %+q not found", name)
+	}
+	return serverTypes[0], nil
+}
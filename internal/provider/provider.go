@@ -0,0 +1,82 @@
+// Package provider defines how job machines are provisioned, so prepare, exec and cleanup run the same way
+// regardless of where the capacity comes from.
+package provider
+
+import (
+	"context"
+	"image"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+)
+
+// Spec describes the machine requested for a job
+type Spec struct {
+	JobID string
+	// Image and Type are provider specific selectors
+	Image        string
+	Type         string
+	Architecture string
+	Location     string
+	// PublicKey has to be authorized to log in as root
+	PublicKey ssh.PublicKey
+	// Rollback tracks the resources created for the job, so they can be removed in reverse order if the job cannot
+	// be prepared; nil if the caller does not roll back
+	Rollback *helper.Rollback
+}
+
+// Type is a resolved machine type
+type Type struct {
+	Name         string
+	Description  string
+	Architecture string
+}
+
+// Image is a resolved image
+type Image struct {
+	ID   string
+	Name string
+}
+
+// DisplayName returns the name of the image, or its id if it has none (e.g. snapshots)
+func (i *Image) DisplayName() string {
+	if i.Name == "" {
+		return "id=" + i.ID
+	}
+	return i.Name
+}
+
+// Machine is a machine provisioned for a job
+type Machine struct {
+	ID      string
+	Name    string
+	Address string
+	SSHPort uint16
+	Created time.Time
+}
+
+// Provider provisions job machines
+type Provider interface {
+	// ResolveType resolves the type selector of the spec
+	ResolveType(ctx context.Context, spec Spec) (*Type, error)
+	// ResolveImage resolves the image selector of the spec for the resolved machine type
+	ResolveImage(ctx context.Context, spec Spec, machineType *Type) (*Image, error)
+	// Create provisions a machine; every created resource is registered with the rollback of the spec, also if
+	// creating the machine fails
+	Create(ctx context.Context, spec Spec, machineType *Type, image *Image) (*Machine, error)
+	// Wait waits until the machine is ready to run jobs
+	Wait(ctx context.Context, machine *Machine, signer ssh.Signer) error
+	// Describe writes the status of a machine which did not become ready
+	Describe(ctx context.Context, report io.Writer, machine *Machine)
+	// Delete removes everything provisioned for the job; it succeeds if nothing is left
+	Delete(ctx context.Context, jobID string) error
+}
+
+// Consoler is implemented by providers offering remote console access to their machines
+type Consoler interface {
+	// CaptureConsole returns the current screen of the machine's console
+	CaptureConsole(ctx context.Context, machine *Machine) (image.Image, error)
+}
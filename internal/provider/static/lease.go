@@ -0,0 +1,73 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const leaseFileSuffix = ".lease"
+
+// errHostLeased signals that the host is leased by another job
+var errHostLeased = errors.New("host is leased by another job")
+
+// leasePath returns the lease file of the host
+func (p *Provider) leasePath(host Host) string {
+	name := strings.NewReplacer(":", "_", "[", "", "]", "").Replace(host.String())
+	return filepath.Join(p.options.LeaseDir, name+leaseFileSuffix)
+}
+
+// acquireLease leases the host to the job by exclusively creating its lease file. Unlike helper.FileLock, leases
+// never become stale, as jobs might run for hours; a left behind lease has to be removed manually.
+func (p *Provider) acquireLease(host Host, jobID string) error {
+	if mkdirError := os.MkdirAll(p.options.LeaseDir, 0700); mkdirError != nil {
+		return mkdirError
+	}
+
+	fh, createError := os.OpenFile(p.leasePath(host), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(createError) {
+		return errHostLeased
+	}
+	if createError != nil {
+		return createError
+	}
+	_, writeError := fh.WriteString(jobID + "\n")
+	return errors.Join(writeError, fh.Close())
+}
+
+// leaseHolder returns the job id the host is leased to, or "" if the host is not leased
+func (p *Provider) leaseHolder(host Host) (string, error) {
+	content, readError := os.ReadFile(p.leasePath(host))
+	if errors.Is(readError, os.ErrNotExist) {
+		return "", nil
+	}
+	if readError != nil {
+		return "", readError
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// releaseLease removes the lease of the host
+func (p *Provider) releaseLease(host Host) error {
+	if removeError := os.Remove(p.leasePath(host)); removeError != nil && !errors.Is(removeError, os.ErrNotExist) {
+		return fmt.Errorf("cannot release lease of %s: %w", host, removeError)
+	}
+	return nil
+}
+
+// leasedHosts returns the hosts leased to the job
+func (p *Provider) leasedHosts(jobID string) ([]Host, error) {
+	var hosts []Host
+	for _, host := range p.options.Hosts {
+		holder, holderError := p.leaseHolder(host)
+		if holderError != nil {
+			return nil, holderError
+		}
+		if holder == jobID {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,240 @@
+// Package static runs jobs on a pool of hosts reachable via ssh, e.g. on-prem or dedicated servers. Each host is
+// leased to a single job and reset by a script instead of being deleted afterward.
+// The hosts have to authorize the key used by hmp for root, so the ssh key source has to be "agent".
+package static
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+// Name is the name the provider is selected by
+const Name = "static"
+
+const defaultSSHPort = 22
+
+// leaseRetryInterval is the delay between lease attempts while all hosts are leased
+var leaseRetryInterval = 5 * time.Second
+
+// Host is a host jobs are run on
+type Host struct {
+	Address string
+	Port    uint16
+}
+
+// String returns the host in the form it is configured in
+func (h Host) String() string {
+	return net.JoinHostPort(h.Address, strconv.Itoa(int(h.Port)))
+}
+
+// ParseHosts parses hosts given as host[:port], separated by ',' or whitespace; the port defaults to 22
+func ParseHosts(hosts string) ([]Host, error) {
+	var parsedHosts []Host
+	for _, host := range strings.FieldsFunc(hosts, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' }) {
+		address, port, splitError := net.SplitHostPort(host)
+		if splitError != nil {
+			// the port is optional
+			address, port = strings.Trim(host, "[]"), strconv.Itoa(defaultSSHPort)
+		}
+		parsedPort, portParseError := strconv.ParseUint(port, 10, 16)
+		if address == "" || portParseError != nil || parsedPort == 0 {
+			return nil, fmt.Errorf("invalid host %+q", host)
+		}
+		parsedHosts = append(parsedHosts, Host{Address: address, Port: uint16(parsedPort)})
+	}
+	return parsedHosts, nil
+}
+
+// ReadHostsFile reads hosts from a file with one or more hosts per line; lines starting with '#' are ignored
+func ReadHostsFile(path string) ([]Host, error) {
+	fh, openError := os.Open(path)
+	if openError != nil {
+		return nil, openError
+	}
+	defer fh.Close()
+
+	var hosts []Host
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		lineHosts, parseError := ParseHosts(line)
+		if parseError != nil {
+			return nil, fmt.Errorf("%s: %w", path, parseError)
+		}
+		hosts = append(hosts, lineHosts...)
+	}
+	return hosts, scanner.Err()
+}
+
+// Options configure the Provider
+type Options struct {
+	Hosts []Host
+	// LeaseDir contains a lease file per leased host; it has to be shared by all hmp processes using the hosts
+	LeaseDir string
+	// LeaseTimeout is the time to wait for a free host
+	LeaseTimeout time.Duration
+	// ResetScript is the path of a script run on the host when the job is cleaned up
+	ResetScript string
+	// Store provides the ssh credentials of the job to run the reset script with
+	Store *helper.StateStore
+}
+
+// Provider leases one of its hosts to each job
+type Provider struct {
+	options Options
+}
+
+var _ provider.Provider = (*Provider)(nil)
+
+// New returns a Provider for the given hosts
+func New(options Options) (*Provider, error) {
+	if len(options.Hosts) == 0 {
+		return nil, errors.New("no static hosts configured")
+	}
+	if options.LeaseDir == "" {
+		return nil, errors.New("no static host lease directory configured")
+	}
+	return &Provider{options: options}, nil
+}
+
+// ResolveType returns the static host type; the architecture is up to the configured hosts
+func (p *Provider) ResolveType(_ context.Context, spec provider.Spec) (*provider.Type, error) {
+	return &provider.Type{Name: Name, Description: "static host", Architecture: spec.Architecture}, nil
+}
+
+// ResolveImage returns the operating system installed on the hosts, as it cannot be chosen
+func (p *Provider) ResolveImage(_ context.Context, _ provider.Spec, _ *provider.Type) (*provider.Image, error) {
+	return &provider.Image{Name: "preinstalled"}, nil
+}
+
+// Create leases a free host to the job, waiting up to the lease timeout if all hosts are leased
+func (p *Provider) Create(ctx context.Context, spec provider.Spec, _ *provider.Type, _ *provider.Image) (*provider.Machine, error) {
+	leaseContext, cancel := context.WithTimeout(ctx, p.options.LeaseTimeout)
+	defer cancel()
+
+	host, leaseError := p.leaseHost(leaseContext, spec.JobID)
+	if leaseError != nil {
+		return nil, leaseError
+	}
+	fmt.Printf("\t\tHost:  %s\n", host)
+	spec.Rollback.Add("lease of host "+host.String(), func(ctx context.Context) error {
+		return p.resetAndRelease(ctx, host, spec.JobID)
+	})
+
+	return &provider.Machine{
+		ID:      host.String(),
+		Name:    host.Address,
+		Address: host.Address,
+		SSHPort: host.Port,
+		Created: time.Now(),
+	}, nil
+}
+
+// Wait waits until the host accepts the ssh key
+func (p *Provider) Wait(ctx context.Context, machine *provider.Machine, signer ssh.Signer) error {
+	return helper.WaitReachable(ctx, signer, machine.Address, machine.SSHPort, helper.SSHRetryDelay)
+}
+
+// leaseHost leases a host to the job; the hosts are tried starting at an offset chosen by the job id, so
+// concurrent jobs do not compete for the same host
+func (p *Provider) leaseHost(ctx context.Context, jobID string) (Host, error) {
+	jobHash := fnv.New32a()
+	jobHash.Write([]byte(jobID))
+	offset := int(jobHash.Sum32() % uint32(len(p.options.Hosts)))
+
+	for {
+		for i := range p.options.Hosts {
+			host := p.options.Hosts[(offset+i)%len(p.options.Hosts)]
+			leaseError := p.acquireLease(host, jobID)
+			if leaseError == nil {
+				return host, nil
+			}
+			if !errors.Is(leaseError, errHostLeased) {
+				return Host{}, leaseError
+			}
+		}
+
+		fmt.Printf("\t\t⏳ All %d hosts are leased, waiting\n", len(p.options.Hosts))
+		select {
+		case <-ctx.Done():
+			return Host{}, fmt.Errorf("no host available: %w", ctx.Err())
+		case <-time.After(leaseRetryInterval):
+		}
+	}
+}
+
+// Describe writes the host a job has been assigned to
+func (p *Provider) Describe(_ context.Context, report io.Writer, machine *provider.Machine) {
+	fmt.Fprintf(report, "host: %s\n", machine.ID)
+}
+
+// Delete resets the hosts leased to the job and releases their leases. A host which fails to reset stays leased,
+// so no further job runs on it until it has been fixed and its lease file removed.
+func (p *Provider) Delete(ctx context.Context, jobID string) error {
+	hosts, leasedHostsError := p.leasedHosts(jobID)
+	if leasedHostsError != nil {
+		return leasedHostsError
+	}
+
+	var deleteErrors []error
+	for _, host := range hosts {
+		deleteErrors = append(deleteErrors, p.resetAndRelease(ctx, host, jobID))
+	}
+	return errors.Join(deleteErrors...)
+}
+
+// resetAndRelease resets the host leased to the job and releases its lease; the host stays leased if it fails to reset
+func (p *Provider) resetAndRelease(ctx context.Context, host Host, jobID string) error {
+	if resetError := p.reset(ctx, host, jobID); resetError != nil {
+		fmt.Printf("\t\t⚠️ Host %s stays leased, remove %s once it has been reset\n", host, p.leasePath(host))
+		return fmt.Errorf("cannot reset %s: %w", host, resetError)
+	}
+	fmt.Printf("\t\tRelease host %s\n", host)
+	return p.releaseLease(host)
+}
+
+// reset runs the reset script on the host with the ssh credentials of the job
+func (p *Provider) reset(ctx context.Context, host Host, jobID string) error {
+	if p.options.ResetScript == "" {
+		return nil
+	}
+	// the state is written as soon as the host is leased, so a missing state cannot prove the host to be clean
+	state, readStateError := p.options.Store.Read(jobID)
+	if errors.Is(readStateError, os.ErrNotExist) {
+		return fmt.Errorf("no state of job %s to reset the host with: %w", jobID, readStateError)
+	}
+	if readStateError != nil {
+		return readStateError
+	}
+
+	script, readScriptError := os.ReadFile(p.options.ResetScript)
+	if readScriptError != nil {
+		return readScriptError
+	}
+	signer, signerError := state.Signer()
+	if signerError != nil {
+		return signerError
+	}
+
+	fmt.Printf("\t\tReset host %s\n", host)
+	sshClient, sshClientError := helper.NewSSHClient(signer, host.Address, host.Port)
+	if sshClientError != nil {
+		return sshClientError
+	}
+	defer sshClient.Close()
+	return sshClient.RunCommand(ctx, string(script))
+}
@@ -0,0 +1,170 @@
+package static
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/fakessh"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/helper"
+	"github.com/bonsai-oss/hetzner-machine-provider/internal/provider"
+)
+
+func TestParseHosts(t *testing.T) {
+	for _, testCase := range []struct {
+		name          string
+		input         string
+		expected      []Host
+		expectedError bool
+	}{
+		{"empty", "", nil, false},
+		{"default port", "ci-1.example.com", []Host{{"ci-1.example.com", 22}}, false},
+		{"multiple", "10.0.0.1:2222, 10.0.0.2\nci-3", []Host{{"10.0.0.1", 2222}, {"10.0.0.2", 22}, {"ci-3", 22}}, false},
+		{"ipv6", "[2001:db8::1]:22,[2001:db8::2]", []Host{{"2001:db8::1", 22}, {"2001:db8::2", 22}}, false},
+		{"invalid port", "ci-1:ssh", nil, true},
+		{"port zero", "ci-1:0", nil, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			hosts, err := ParseHosts(testCase.input)
+			assert.Equal(t, testCase.expectedError, err != nil)
+			assert.Equal(t, testCase.expected, hosts)
+		})
+	}
+}
+
+func TestReadHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	assert.NoError(t, os.WriteFile(path, []byte("# robot servers\nci-1.example.com\n\nci-2.example.com:2222 # moved sshd\n"), 0o600))
+
+	hosts, err := ReadHostsFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Host{{"ci-1.example.com", 22}, {"ci-2.example.com", 2222}}, hosts)
+
+	assert.NoError(t, os.WriteFile(path, []byte("ci-1:ssh\n"), 0o600))
+	_, err = ReadHostsFile(path)
+	assert.Error(t, err)
+}
+
+func TestProvider(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	assert.NoError(t, err)
+	server, err := fakessh.Start(fakessh.Options{AuthorizedKeys: []ssh.PublicKey{signer.PublicKey()}})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	_, err = New(Options{LeaseDir: t.TempDir()})
+	assert.Error(t, err)
+	staticProvider, err := New(Options{Hosts: []Host{{server.Host(), server.Port()}}, LeaseDir: t.TempDir(), LeaseTimeout: time.Second})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	spec := provider.Spec{JobID: "1234", Architecture: "amd64", PublicKey: signer.PublicKey()}
+	machineType, err := staticProvider.ResolveType(ctx, spec)
+	assert.NoError(t, err)
+	image, err := staticProvider.ResolveImage(ctx, spec, machineType)
+	assert.NoError(t, err)
+	machine, err := staticProvider.Create(ctx, spec, machineType, image)
+	assert.NoError(t, err)
+	assert.Equal(t, server.Port(), machine.SSHPort)
+
+	waitContext, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, staticProvider.Wait(waitContext, machine, signer))
+	assert.NoError(t, staticProvider.Delete(ctx, spec.JobID))
+}
+
+func TestLeases(t *testing.T) {
+	retryInterval := leaseRetryInterval
+	leaseRetryInterval = 50 * time.Millisecond
+	t.Cleanup(func() { leaseRetryInterval = retryInterval })
+
+	hosts := []Host{{"10.0.0.1", 22}, {"10.0.0.2", 22}}
+	staticProvider, err := New(Options{Hosts: hosts, LeaseDir: t.TempDir(), LeaseTimeout: 200 * time.Millisecond})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	leased := map[string]string{}
+	for _, jobID := range []string{"1", "2"} {
+		machine, createError := staticProvider.Create(ctx, provider.Spec{JobID: jobID}, nil, nil)
+		assert.NoError(t, createError)
+		leased[jobID] = machine.Address
+	}
+	assert.NotEqual(t, leased["1"], leased["2"])
+
+	// all hosts are leased until a job is cleaned up
+	_, err = staticProvider.Create(ctx, provider.Spec{JobID: "3"}, nil, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, staticProvider.Delete(ctx, "1"))
+	// deleting is idempotent
+	assert.NoError(t, staticProvider.Delete(ctx, "1"))
+	machine, err := staticProvider.Create(ctx, provider.Spec{JobID: "3"}, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, leased["1"], machine.Address)
+
+	holders := helper.Map(hosts, func(host Host) string {
+		holder, _ := staticProvider.leaseHolder(host)
+		return holder
+	})
+	assert.ElementsMatch(t, []string{"2", "3"}, holders)
+}
+
+func TestReset(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	assert.NoError(t, err)
+	privateKeyBlock, err := ssh.MarshalPrivateKey(privateKey, "")
+	assert.NoError(t, err)
+	server, err := fakessh.Start(fakessh.Options{AuthorizedKeys: []ssh.PublicKey{signer.PublicKey()}})
+	assert.NoError(t, err)
+	defer server.Close()
+
+	for _, testCase := range []struct {
+		name           string
+		script         string
+		writeState     bool
+		expectedReset  bool
+		expectedError  bool
+		expectedLeased bool
+	}{
+		{"reset", "rm -f build.log", true, true, false, false},
+		{"reset fails", "rm -f build.log; exit 1", true, true, true, true},
+		{"missing state", "rm -f build.log", false, false, true, true},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.NoError(t, os.WriteFile(filepath.Join(server.Dir, "build.log"), []byte("build\n"), 0o600))
+			scriptPath := filepath.Join(t.TempDir(), "reset.sh")
+			assert.NoError(t, os.WriteFile(scriptPath, []byte(testCase.script), 0o600))
+			store := &helper.StateStore{Backend: &helper.LocalStateBackend{BaseDir: t.TempDir()}, Secret: "secret"}
+			if testCase.writeState {
+				assert.NoError(t, store.Write("1234", &helper.State{
+					SSHPrivateKey: string(pem.EncodeToMemory(privateKeyBlock)),
+					ServerAddress: server.Host(),
+					SSHPort:       server.Port(),
+				}))
+			}
+
+			host := Host{server.Host(), server.Port()}
+			staticProvider, err := New(Options{Hosts: []Host{host}, LeaseDir: t.TempDir(), LeaseTimeout: time.Second, ResetScript: scriptPath, Store: store})
+			assert.NoError(t, err)
+			_, err = staticProvider.Create(context.Background(), provider.Spec{JobID: "1234"}, nil, nil)
+			assert.NoError(t, err)
+
+			err = staticProvider.Delete(context.Background(), "1234")
+			assert.Equal(t, testCase.expectedError, err != nil)
+			holder, _ := staticProvider.leaseHolder(host)
+			assert.Equal(t, testCase.expectedLeased, holder == "1234")
+			_, statError := os.Stat(filepath.Join(server.Dir, "build.log"))
+			assert.Equal(t, testCase.expectedReset, os.IsNotExist(statError))
+		})
+	}
+}